@@ -0,0 +1,253 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// WriteBatch holds a group of mutations which are written
+// to the tree atomically by LSMTree.Write.
+type WriteBatch struct {
+	entries []entry
+	// seq is the sequence number of the first mutation, it is assigned when
+	// the batch is written. The next mutations get the next numbers, so the
+	// later mutation of the same key wins.
+	seq uint64
+}
+
+// batchSequenceFlag is set in the entry number of the batch which is encoded
+// with the sequence number. The batches written by the older version have no
+// sequence number, it is assigned on recovery.
+const batchSequenceFlag = 1 << 62
+
+// batchKindFlag is set in the entry number of the batch which contains range
+// deletions or expiring values, then every entry is prefixed by its kind.
+const batchKindFlag = 1 << 61
+
+// NewWriteBatch creates a new empty batch.
+func NewWriteBatch() *WriteBatch {
+	return &WriteBatch{entries: make([]entry, 0)}
+}
+
+// Put adds the key-value pair to the batch.
+func (b *WriteBatch) Put(key, value []byte) {
+	b.entries = append(b.entries, entry{key: copyBytes(key), value: copyBytes(value)})
+}
+
+// PutWithTTL adds the key-value pair which expires after the given ttl to the
+// batch. The expiration time is counted from the call, the non-positive ttl
+// makes the value expire immediately.
+func (b *WriteBatch) PutWithTTL(key, value []byte, ttl time.Duration) {
+	b.entries = append(b.entries, entry{
+		key:       copyBytes(key),
+		value:     copyBytes(value),
+		expiresAt: time.Now().Add(ttl).UnixNano(),
+	})
+}
+
+// Delete adds the deletion of the key to the batch.
+func (b *WriteBatch) Delete(key []byte) {
+	b.entries = append(b.entries, entry{key: copyBytes(key), value: nil})
+}
+
+// DeleteRange adds the deletion of all keys in range [start, end) to the batch.
+// It is written as a single range tombstone instead of a tombstone per key.
+func (b *WriteBatch) DeleteRange(start, end []byte) {
+	b.entries = append(b.entries, entry{key: copyBytes(start), value: copyBytes(end), rangeDelete: true})
+}
+
+// Len returns the number of mutations in the batch.
+func (b *WriteBatch) Len() int {
+	return len(b.entries)
+}
+
+// size returns the total size of keys and values in the batch.
+func (b *WriteBatch) size() int {
+	size := 0
+	for _, e := range b.entries {
+		size += len(e.key) + len(e.value)
+	}
+	return size
+}
+
+// Reset removes all mutations from the batch.
+func (b *WriteBatch) Reset() {
+	b.entries = b.entries[:0]
+}
+
+// validate checks all mutations of the batch.
+func (b *WriteBatch) validate() error {
+	for _, e := range b.entries {
+		var err error
+		if e.rangeDelete {
+			err = validateRange(e.key, e.value)
+		} else {
+			err = validateEntry(e.key, e.value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// validateRange checks the bounds of the range deletion, both of them
+// are validated as keys and start must be less than end.
+func validateRange(start, end []byte) error {
+	if err := validateEntry(start, nil); err != nil {
+		return err
+	}
+	if err := validateEntry(end, nil); err != nil {
+		return err
+	}
+	if bytes.Compare(start, end) >= 0 {
+		return ErrInvalidRange
+	}
+
+	return nil
+}
+
+// hasKinds returns true if the batch contains range deletions or expiring
+// values, whose kinds must be encoded.
+func (b *WriteBatch) hasKinds() bool {
+	for _, e := range b.entries {
+		if kind := e.kind(); kind == kindRangeDelete || kind == kindExpiringPut {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEntry checks the key and the value of the mutation, the nil value
+// is the deletion of the key.
+func validateEntry(key, value []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > MaxKeySize {
+		return ErrKeyTooLarge
+	} else if value == nil {
+		return nil
+	} else if len(value) == 0 {
+		return ErrValueRequired
+	} else if uint64(len(value)) > MaxValueSize {
+		return ErrValueTooLarge
+	}
+
+	return nil
+}
+
+// encodeBatch encodes the batch into a slice of bytes.
+//
+//	Encode format:
+//	[encode entry number | batchSequenceFlag][sequence number]
+//	[encoded entry]...[encoded entry]
+//
+// If the batch contains range deletions or expiring values, batchKindFlag is
+// set and every encoded entry is prefixed by one byte of its kind. The range
+// deletion is encoded as the entry with the start key and the end key as the
+// value. The kind of the expiring value is followed by the expiration time.
+//
+// The function must be compatible with decodeBatch.
+func encodeBatch(b *WriteBatch) ([]byte, error) {
+	var buf bytes.Buffer
+	withKinds := b.hasKinds()
+	num := len(b.entries) | batchSequenceFlag
+	if withKinds {
+		num |= batchKindFlag
+	}
+	buf.Write(encodeInt(num))
+	buf.Write(encodeInt(int(b.seq)))
+	for _, e := range b.entries {
+		if withKinds {
+			buf.WriteByte(byte(e.kind()))
+			if e.kind() == kindExpiringPut {
+				buf.Write(encodeInt(int(e.expiresAt)))
+			}
+		}
+		if _, err := encode(e.key, e.value, &buf); err != nil {
+			return nil, fmt.Errorf("failed to encode entry: %w", err)
+		}
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeBatch decodes the batch from the slice of bytes.
+// The function must be compatible with encodeBatch.
+func decodeBatch(data []byte) (*WriteBatch, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("the batch is corrupted, failed to read entry number")
+	}
+
+	num := decodeInt(data[0:8])
+	data = data[8:]
+	seq := uint64(0)
+	if num&batchSequenceFlag != 0 {
+		if len(data) < 8 {
+			return nil, fmt.Errorf("the batch is corrupted, failed to read sequence number")
+		}
+		num &^= batchSequenceFlag
+		seq = uint64(decodeInt(data[0:8]))
+		data = data[8:]
+	}
+	withKinds := num&batchKindFlag != 0
+	num &^= batchKindFlag
+	// every encoded entry takes at least 16 bytes: the entry and key lengths.
+	if num < 0 || num > len(data)/16 {
+		return nil, fmt.Errorf("the batch is corrupted, bad entry number %d", num)
+	}
+
+	r := bytes.NewReader(data)
+	b := &WriteBatch{entries: make([]entry, 0, num), seq: seq}
+	for i := 0; i < num; i++ {
+		kind, expiresAt := kindPut, int64(0)
+		if withKinds {
+			k, err := r.ReadByte()
+			if err != nil {
+				return nil, fmt.Errorf("the batch is corrupted, expected %d entries, actual %d", num, i)
+			}
+			kind = recordKind(k)
+		}
+		if kind == kindExpiringPut {
+			var buf [8]byte
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, fmt.Errorf("the batch is corrupted, failed to read expiration time of entry %d", i)
+			}
+			expiresAt = int64(decodeInt(buf[:]))
+		}
+
+		key, value, err := decode(r)
+		if err != nil {
+			if err == io.EOF {
+				return nil, fmt.Errorf("the batch is corrupted, expected %d entries, actual %d", num, i)
+			}
+			return nil, fmt.Errorf("failed to decode entry: %w", err)
+		}
+		switch kind {
+		case kindRangeDelete:
+			b.entries = append(b.entries, entry{key: key, value: value, rangeDelete: true})
+		case kindDelete:
+			b.entries = append(b.entries, entry{key: key})
+		case kindPut, kindExpiringPut:
+			b.entries = append(b.entries, entry{key: key, value: value, expiresAt: expiresAt})
+		default:
+			return nil, fmt.Errorf("the batch is corrupted, unknown kind %d of entry %d", kind, i)
+		}
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("the batch is corrupted, %d bytes after %d entries", r.Len(), num)
+	}
+
+	return b, nil
+}
+
+// copyBytes returns the copy of the given slice, the copy is never nil.
+func copyBytes(b []byte) []byte {
+	return append(make([]byte, 0, len(b)), b...)
+}
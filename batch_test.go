@@ -0,0 +1,122 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestEncodeBatch(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("va"))
+	batch.Delete([]byte("b"))
+	batch.Put([]byte("c"), []byte("vc"))
+	batch.seq = 42
+
+	data, err := encodeBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, err := decodeBatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.Len() != batch.Len() || decoded.seq != batch.seq {
+		t.Fatalf("decodeBatch expected len=%d seq=%d, actual len=%d seq=%d", batch.Len(), batch.seq, decoded.Len(), decoded.seq)
+	}
+	for i, e := range batch.entries {
+		d := decoded.entries[i]
+		if !bytes.Equal(e.key, d.key) || !bytes.Equal(e.value, d.value) || (e.value == nil) != (d.value == nil) {
+			t.Fatalf("decodeBatch expected entry=%v, actual entry=%v", e, d)
+		}
+	}
+
+	if _, err := decodeBatch(data[:len(data)-1]); err == nil {
+		t.Fatalf("decodeBatch expected error for truncated batch")
+	}
+}
+
+func TestLSMTree_Write(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tree.Put([]byte("from"), []byte("100")); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("from"), []byte("50"))
+	batch.Put([]byte("to"), []byte("50"))
+	batch.Put([]byte(""), []byte("invalid"))
+	if err := tree.Write(batch); err != ErrKeyRequired {
+		t.Fatalf("Write expected err=%v, actual err=%v", ErrKeyRequired, err)
+	}
+	if _, ok, _ := tree.Get([]byte("to")); ok {
+		t.Fatalf("Write must not apply invalid batch")
+	}
+
+	batch.Reset()
+	batch.Put([]byte("from"), []byte("50"))
+	batch.Put([]byte("to"), []byte("50"))
+	if err := tree.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the crash in the middle of the second transfer.
+	walPath := walSegmentPath(dbDir, 0)
+	batch.Reset()
+	batch.Put([]byte("from"), []byte("0"))
+	batch.Put([]byte("to"), []byte("100"))
+	data, err := encodeBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := append(encodeInt(len(data)), data...)
+	if _, err := wal.Write(record[:len(record)-3]); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatalf("Open error: %s", err)
+	}
+	defer tree.Close()
+
+	for _, c := range []struct{ key, value string }{{"from", "50"}, {"to", "50"}} {
+		value, ok, err := tree.Get([]byte(c.key))
+		if err != nil || !ok || string(value) != c.value {
+			t.Fatalf("Get key=%s expected value=%s, actual value=%s ok=%v err=%v", c.key, c.value, value, ok, err)
+		}
+	}
+
+	if err := tree.Put([]byte("after"), []byte("crash")); err != nil {
+		t.Fatal(err)
+	}
+	if err := replayWAL(tree.wal, newMemTable(), AbsoluteConsistency, &RecoveryStats{}, true, false, false); err != nil {
+		t.Fatal(fmt.Errorf("the WAL must be readable after the torn batch: %w", err))
+	}
+}
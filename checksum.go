@@ -0,0 +1,62 @@
+package lsmtree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// checksumSize is the size of the encoded checksum in bytes.
+	checksumSize = 4
+)
+
+// crc32cTable is the table of CRC-32C (Castagnoli) polynomial.
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns CRC-32C checksum of the data.
+func checksum(data ...[]byte) uint32 {
+	crc := uint32(0)
+	for _, d := range data {
+		crc = crc32.Update(crc, crc32cTable, d)
+	}
+	return crc
+}
+
+// encodeChecksum encodes CRC-32C checksum of the data.
+// The function must be compatible with verifyChecksum.
+func encodeChecksum(data ...[]byte) []byte {
+	var buf [checksumSize]byte
+	binary.BigEndian.PutUint32(buf[:], checksum(data...))
+	return buf[:]
+}
+
+// verifyChecksum returns true if the encoded checksum matches the data.
+func verifyChecksum(encoded []byte, data ...[]byte) bool {
+	return binary.BigEndian.Uint32(encoded) == checksum(data...)
+}
+
+// CorruptionError is returned when the data read from the file is corrupted,
+// for example the checksum does not match. It matches ErrCorruption, so
+// errors.Is(err, ErrCorruption) reports whether the data is corrupted.
+type CorruptionError struct {
+	// Path is the path of the corrupted file.
+	Path string
+	// Offset is the offset of the corrupted record or block in the file.
+	Offset int64
+	// Reason describes the corruption.
+	Reason string
+}
+
+// Error implements error.
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: file %s at offset %d: %s", ErrCorruption, e.Path, e.Offset, e.Reason)
+}
+
+// Is implements errors.Is.
+func (e *CorruptionError) Is(target error) bool {
+	return target == ErrCorruption
+}
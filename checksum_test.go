@@ -0,0 +1,106 @@
+package lsmtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestWALCorruption(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		if err := tree.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	walPath := walSegmentPath(dbDir, 0)
+	data, err := ioutil.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recordSize := len(data) / 3
+	data[recordSize+walHeaderSize+1] ^= 0xff
+	if err := ioutil.WriteFile(walPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = Open(dbDir)
+	var corruption *CorruptionError
+	if !errors.Is(err, ErrCorruption) || !errors.As(err, &corruption) {
+		t.Fatalf("Open expected corruption error, actual err=%v", err)
+	}
+	if corruption.Path != walPath || corruption.Offset != int64(recordSize) {
+		t.Fatalf("corruption expected path=%s offset=%d, actual path=%s offset=%d",
+			walPath, recordSize, corruption.Path, corruption.Offset)
+	}
+}
+
+func TestSsTableCorruption(t *testing.T) {
+	dbDir, close, err := prepareSsTable(prepareMemTable(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close()
+
+	r, err := openSsTable(dbDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block := r.blocks[1]
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tablePath := path.Join(dbDir, "0-"+ssTableFileName)
+	data, err := ioutil.ReadFile(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[block.offset+block.size-1] ^= 0xff
+	if err := ioutil.WriteFile(tablePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok, err := searchInSsTable(dbDir, 0, []byte("a"), maxSequence); err != nil || !ok {
+		t.Fatalf("searchInSsTable in the valid block expected ok=true, actual ok=%v err=%v", ok, err)
+	}
+
+	_, _, err = searchInSsTable(dbDir, 0, block.key, maxSequence)
+	var corruption *CorruptionError
+	if !errors.Is(err, ErrCorruption) || !errors.As(err, &corruption) {
+		t.Fatalf("searchInSsTable expected corruption error, actual err=%v", err)
+	}
+	if corruption.Path != tablePath || corruption.Offset != int64(block.offset) {
+		t.Fatalf("corruption expected path=%s offset=%d, actual path=%s offset=%d",
+			tablePath, block.offset, corruption.Path, corruption.Offset)
+	}
+
+	it, err := newTableIterator(newTableCache(dbDir, 0), 0)
+	if err == nil {
+		for err == nil && it.hasNext() {
+			_, err = it.next()
+		}
+		_ = it.close()
+	}
+	if !errors.Is(err, ErrCorruption) {
+		t.Fatalf("tableIterator expected corruption error, actual err=%v", err)
+	}
+}
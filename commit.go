@@ -0,0 +1,275 @@
+package lsmtree
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// defaultSyncInterval is default interval between the WAL syncs in SyncPeriodic mode.
+	defaultSyncInterval = 100 * time.Millisecond
+	// maxWriteGroupSize is the max size of keys and values in bytes written by the single
+	// group commit, so the leader does not wait too long for the large group.
+	maxWriteGroupSize = 1 << 20 // 1MB
+)
+
+// SyncMode decides when the WAL file is synced to the stable storage.
+type SyncMode int
+
+const (
+	// SyncAlways is default mode. The WAL file is synced after every write,
+	// so the written data survives the machine crash.
+	SyncAlways SyncMode = iota
+	// SyncPeriodic syncs the WAL file at most once per sync interval. The data
+	// written during the last interval may be lost on the machine crash.
+	SyncPeriodic
+	// SyncNever leaves syncing the WAL file to the operating system. The data
+	// survives the process crash, but not the machine crash.
+	SyncNever
+)
+
+// WriteOptions are the options of the single write.
+type WriteOptions struct {
+	// Sync forces the WAL file to be synced before the write returns,
+	// regardless of SyncMode.
+	Sync bool
+}
+
+// WALSyncMode sets the WAL sync mode for LSMTree.
+func WALSyncMode(mode SyncMode) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.syncMode = mode
+	}
+}
+
+// WALSyncInterval sets the interval between the WAL syncs in SyncPeriodic mode for LSMTree.
+// The interval must be positive, Open fails otherwise.
+func WALSyncInterval(interval time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.syncInterval = interval
+	}
+}
+
+// writer is the write waiting in the queue for the group commit.
+type writer struct {
+	batch *WriteBatch
+	sync  bool
+	// check is called before the batch is committed, the batch is not
+	// committed if it fails. It is used by the transactions.
+	check func() error
+	done  bool
+	err   error
+	cond  *sync.Cond
+}
+
+// WriteWithOptions applies all mutations of the batch atomically like Write,
+// using the given options.
+//
+// The concurrent writes are committed in groups: the writer at the head of
+// the queue becomes the leader, writes the batches of the queued writers
+// into the WAL file and syncs it once for the whole group, then it wakes up
+// the other writers of the group.
+func (t *LSMTree) WriteWithOptions(batch *WriteBatch, options WriteOptions) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if err := batch.validate(); err != nil {
+		return err
+	}
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	w := &writer{batch: batch, sync: options.Sync, cond: sync.NewCond(&t.queueMu)}
+
+	t.queueMu.Lock()
+	t.queue = append(t.queue, w)
+	for !w.done && t.queue[0] != w {
+		w.cond.Wait()
+	}
+	if w.done {
+		t.queueMu.Unlock()
+		return w.err
+	}
+
+	group, size := make([]*writer, 0, len(t.queue)), 0
+	for _, qw := range t.queue {
+		if len(group) > 0 && size+qw.batch.size() > maxWriteGroupSize {
+			break
+		}
+		group = append(group, qw)
+		size += qw.batch.size()
+	}
+	t.queueMu.Unlock()
+
+	err := t.commitGroup(group)
+
+	t.queueMu.Lock()
+	for _, gw := range group {
+		gw.done, gw.err = true, err
+		gw.cond.Signal()
+	}
+	t.queue = t.queue[len(group):]
+	if len(t.queue) > 0 {
+		t.queue[0].cond.Signal()
+	}
+	t.queueMu.Unlock()
+
+	return err
+}
+
+// commitGroup writes the batches of the group into the WAL file and applies
+// them to the MemTable.
+func (t *LSMTree) commitGroup(group []*writer) error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.mu.RLock()
+	bgErr := t.bgErr
+	t.mu.RUnlock()
+	if bgErr != nil {
+		return fmt.Errorf("background error: %w", bgErr)
+	}
+
+	for _, w := range group {
+		if w.check != nil {
+			if err := w.check(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// every mutation gets its own sequence number.
+	batches := make([]*WriteBatch, 0, len(group))
+	seq := t.lastSequence
+	sync := t.syncMode == SyncAlways ||
+		(t.syncMode == SyncPeriodic && time.Since(t.lastSyncTime) >= t.syncInterval)
+	for _, w := range group {
+		w.batch.seq = seq + 1
+		seq += uint64(w.batch.Len())
+		batches = append(batches, w.batch)
+		sync = sync || w.sync
+	}
+	if seq > maxSequence {
+		return fmt.Errorf("sequence number overflow")
+	}
+
+	n, err := appendToWAL(t.wal, false, batches...)
+	if err != nil {
+		return t.dropWALTail(fmt.Errorf("failed to write wal %s: %w", t.wal.Name(), err), false)
+	}
+	if sync {
+		if err := t.wal.Sync(); err != nil {
+			return t.dropWALTail(fmt.Errorf("failed to sync wal %s: %w", t.wal.Name(), err), true)
+		}
+		t.walDirty, t.lastSyncTime = false, time.Now()
+		t.walSyncs++
+	} else {
+		t.walDirty = true
+	}
+	t.walSize += int64(n)
+
+	t.mu.Lock()
+	for _, batch := range batches {
+		t.mt.apply(batch)
+	}
+	t.lastSequence = seq
+	t.mu.Unlock()
+
+	atomic.StoreInt64(&t.lastWriteTime, time.Now().UnixNano())
+
+	// the group is already committed, so the failure to rotate the MemTable
+	// or the WAL segment fails the following writes instead of this one.
+	if t.mt.bytes() > t.memTableSizeThreshold {
+		if err = t.freezeMemTable(); err != nil {
+			err = fmt.Errorf("failed to freeze memtable: %w", err)
+		}
+	} else if t.walSize >= int64(t.walSegmentSize) {
+		t.mu.Lock()
+		err = t.switchWAL()
+		t.mu.Unlock()
+		if err != nil {
+			err = fmt.Errorf("failed to start wal segment: %w", err)
+		}
+	}
+	if err != nil {
+		t.mu.Lock()
+		if t.bgErr == nil {
+			t.bgErr = err
+		}
+		t.mu.Unlock()
+	}
+
+	return nil
+}
+
+// dropWALTail truncates the WAL segment to the size of the committed records
+// after the failed write, so the next records do not follow the torn one and
+// the failed batches are not replayed. The records which failed to be synced
+// may be durable anyway, and the failed truncation leaves them in the segment,
+// so then the error is set to bgErr and all later writes fail, otherwise they
+// would reuse the sequence numbers of the failed batches. Returns the error.
+// The caller must hold writeMu.
+func (t *LSMTree) dropWALTail(err error, latch bool) error {
+	if truncateErr := t.wal.Truncate(t.walSize); truncateErr != nil {
+		err = fmt.Errorf("%v, failed to truncate: %w", err, truncateErr)
+		latch = true
+	}
+
+	if latch {
+		t.mu.Lock()
+		if t.bgErr == nil {
+			t.bgErr = err
+		}
+		t.mu.Unlock()
+	}
+	return err
+}
+
+// syncWAL syncs the WAL file if it has unsynced writes. The caller must hold writeMu.
+func (t *LSMTree) syncWAL() error {
+	if !t.walDirty {
+		return nil
+	}
+
+	if err := t.wal.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal %s: %w", t.wal.Name(), err)
+	}
+
+	t.walDirty, t.lastSyncTime = false, time.Now()
+	t.walSyncs++
+	return nil
+}
+
+// runWALSync periodically syncs the WAL file in SyncPeriodic mode, so the writes
+// followed by no other writes are synced too.
+func (t *LSMTree) runWALSync() {
+	defer t.syncWg.Done()
+
+	ticker := time.NewTicker(t.syncInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.closing:
+			return
+		case <-ticker.C:
+		}
+
+		t.writeMu.Lock()
+		err := t.syncWAL()
+		t.writeMu.Unlock()
+
+		if err != nil {
+			t.mu.Lock()
+			t.bgErr = err
+			t.mu.Unlock()
+			return
+		}
+	}
+}
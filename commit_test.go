@@ -0,0 +1,197 @@
+package lsmtree
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestLSMTree_GroupCommit(t *testing.T) {
+	modes := []SyncMode{SyncAlways, SyncPeriodic, SyncNever}
+	for _, mode := range modes {
+		dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+		if err != nil {
+			t.Fatalf("failed to create temp dir: %s", err)
+		}
+		defer os.RemoveAll(dbDir)
+
+		tree, err := Open(dbDir, WALSyncMode(mode), WALSyncInterval(time.Millisecond))
+		if err != nil {
+			t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+		}
+
+		var wg sync.WaitGroup
+		for w := 0; w < 8; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w * 50; i < (w+1)*50; i++ {
+					key := []byte(fmt.Sprintf("key-%04d", i))
+					if err := tree.Put(key, key); err != nil {
+						t.Errorf("Put error: %s", err)
+						return
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		if err := tree.Close(); err != nil {
+			t.Fatalf("failed to close: %s", err)
+		}
+
+		tree, err = Open(dbDir, WALSyncMode(mode))
+		if err != nil {
+			t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+		}
+		checkRange(t, tree, 0, 400)
+		if err := tree.Close(); err != nil {
+			t.Fatalf("failed to close: %s", err)
+		}
+	}
+
+	// the writers queued while the leader is writing are committed by the
+	// single group with the single WAL sync.
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	tree.writeMu.Lock()
+	syncs := tree.walSyncs
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			key := []byte(fmt.Sprintf("key-%04d", w))
+			if err := tree.Put(key, key); err != nil {
+				t.Errorf("Put error: %s", err)
+			}
+		}(w)
+	}
+	for queued := 0; queued < 8; {
+		time.Sleep(time.Millisecond)
+		tree.queueMu.Lock()
+		queued = len(tree.queue)
+		tree.queueMu.Unlock()
+	}
+	tree.writeMu.Unlock()
+	wg.Wait()
+
+	tree.writeMu.Lock()
+	syncs = tree.walSyncs - syncs
+	tree.writeMu.Unlock()
+	if syncs > 2 {
+		t.Fatalf("8 queued writers expected to be committed by at most 2 groups, actual %d WAL syncs", syncs)
+	}
+	checkRange(t, tree, 0, 8)
+
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dbDir)
+	if _, err := Open(dbDir, WALSyncInterval(0)); err == nil || err == ErrLocked {
+		t.Fatalf("Open must fail with zero WAL sync interval, actual err=%v", err)
+	}
+	// the directory is unlocked after the failure.
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	_ = tree.Close()
+}
+
+func TestLSMTree_WriteWithOptions(t *testing.T) {
+	tree, closer := prepareTree(t, WALSyncMode(SyncNever))
+	defer closer()
+
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	if err := tree.WriteWithOptions(batch, WriteOptions{}); err != nil {
+		t.Fatalf("WriteWithOptions error: %s", err)
+	}
+	tree.writeMu.Lock()
+	dirty := tree.walDirty
+	tree.writeMu.Unlock()
+	if !dirty {
+		t.Fatalf("the WAL must not be synced in SyncNever mode")
+	}
+
+	batch = NewWriteBatch()
+	batch.Put([]byte("b"), []byte("2"))
+	if err := tree.WriteWithOptions(batch, WriteOptions{Sync: true}); err != nil {
+		t.Fatalf("WriteWithOptions error: %s", err)
+	}
+	tree.writeMu.Lock()
+	dirty = tree.walDirty
+	tree.writeMu.Unlock()
+	if dirty {
+		t.Fatalf("the WAL must be synced by the write with Sync option")
+	}
+}
+
+func TestLSMTree_WALWriteError(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 10)
+
+	// the torn record left by the failed write is truncated, so the next
+	// records follow the committed ones.
+	tree.writeMu.Lock()
+	if _, err := tree.wal.Write([]byte("torn")); err != nil {
+		tree.writeMu.Unlock()
+		t.Fatal(err)
+	}
+	_ = tree.dropWALTail(fmt.Errorf("injected error"), false)
+	tree.writeMu.Unlock()
+	putRange(t, tree, 10, 20)
+
+	// the failed write, which cannot be truncated, fails all later writes.
+	tree.writeMu.Lock()
+	wal := tree.wal
+	tree.wal, err = os.Open(wal.Name())
+	tree.writeMu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("key-0020"), []byte("key-0020")); err == nil {
+		t.Fatalf("Put expected to fail on the WAL write error")
+	}
+	if err := tree.Put([]byte("key-0021"), []byte("key-0021")); err == nil {
+		t.Fatalf("Put expected to fail after the WAL write error")
+	}
+	if err := tree.Close(); err == nil {
+		t.Fatalf("Close expected to report the WAL write error")
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the recovery in the default mode finds no corruption.
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 20)
+	if _, ok, err := tree.Get([]byte("key-0020")); err != nil || ok {
+		t.Fatalf("Get expected the failed write to be absent, actual ok=%v err=%v", ok, err)
+	}
+	if stats := tree.RecoveryStats(); stats.Records != 20 || len(stats.Corruptions) != 0 {
+		t.Fatalf("the recovery expected 20 records without corruptions, actual %+v", stats)
+	}
+}
@@ -0,0 +1,650 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// defaultCompactionIdleInterval is default time without writes after which
+	// the idle compaction is run.
+	defaultCompactionIdleInterval = 30 * time.Second
+	// compactionCheckInterval is the interval between the periodical checks
+	// of the compaction scheduler.
+	compactionCheckInterval = time.Second
+	// defaultLevelBaseSize is default size limit of level 1 in bytes.
+	defaultLevelBaseSize = 10 * defaultMemTableThreshold
+	// defaultLevelSizeMultiplier is default ratio between the size limits of the neighbour levels.
+	defaultLevelSizeMultiplier = 10
+	// defaultSsTableTargetSize is default size of SSTable created by the compaction.
+	defaultSsTableTargetSize = 2 * defaultMemTableThreshold
+)
+
+// CompactionState describes the state of the tree for the compaction scheduler.
+type CompactionState struct {
+	// SsTableNum is the current number of SSTables.
+	SsTableNum int
+	// SsTableNumberThreshold is the configured SSTable number threshold.
+	SsTableNumberThreshold int
+	// Score is the compaction score computed by the compaction strategy. The
+	// compaction is required when the score reaches 1. With LeveledStrategy it
+	// happens when the number of tables in level 0 reaches SsTableNumberThreshold
+	// or the size of the deeper level reaches its limit, with SizeTieredStrategy
+	// when SsTableNumberThreshold tables of similar size are found. The score
+	// is 0 if there is nothing to compact.
+	Score float64
+	// IdleTime is the time passed since the last write.
+	IdleTime time.Duration
+}
+
+// CompactionStats describes the work done by the compactions since the
+// tree is opened.
+type CompactionStats struct {
+	// PurgedTombstones is the number of the key tombstones dropped together
+	// with the values they shadow, since they are not needed anymore.
+	PurgedTombstones int
+	// PurgedRangeTombstones is the number of the dropped range tombstones.
+	PurgedRangeTombstones int
+	// ExpiredValues is the number of the dropped expired values, they are
+	// replaced with the tombstones, which are purged later.
+	ExpiredValues int
+}
+
+// CompactionStats returns the work done by the compactions.
+func (t *LSMTree) CompactionStats() CompactionStats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.compactionStats
+}
+
+// Scheduler decides when the background compaction must run.
+type Scheduler interface {
+	// ShouldCompact returns true if the compaction must run for the given state.
+	// It is called after every flush and periodically by the compaction goroutine.
+	ShouldCompact(state CompactionState) bool
+}
+
+// thresholdScheduler is default Scheduler. It runs the compaction when the score
+// reaches 1 or when the tree is idle for idleInterval.
+type thresholdScheduler struct {
+	idleInterval time.Duration
+}
+
+// NewThresholdScheduler creates Scheduler which runs the compaction when any level
+// reaches its limit or when there were no writes for the idleInterval. The idle
+// compaction moves the tables to the deeper levels. The non-positive idleInterval
+// disables the idle compaction.
+func NewThresholdScheduler(idleInterval time.Duration) Scheduler {
+	return &thresholdScheduler{idleInterval: idleInterval}
+}
+
+// ShouldCompact implements Scheduler.
+func (s *thresholdScheduler) ShouldCompact(state CompactionState) bool {
+	if state.Score >= 1 {
+		return true
+	}
+
+	return s.idleInterval > 0 && state.IdleTime >= s.idleInterval && state.Score > 0
+}
+
+// CompactionScheduler sets the scheduler of the background compaction for LSMTree.
+func CompactionScheduler(scheduler Scheduler) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.scheduler = scheduler
+	}
+}
+
+// PauseCompactions stops running the background compaction, for example during
+// the bulk load. The compaction in progress is not interrupted.
+func (t *LSMTree) PauseCompactions() {
+	atomic.StoreInt32(&t.compactionPaused, 1)
+}
+
+// ResumeCompactions resumes the background compaction paused by PauseCompactions.
+func (t *LSMTree) ResumeCompactions() {
+	atomic.StoreInt32(&t.compactionPaused, 0)
+	t.triggerCompaction()
+}
+
+// WaitForCompactions blocks until the compaction in progress is finished and
+// the scheduler does not request more compactions. The compactions requested
+// while waiting are run in the caller goroutine. It does not wait if the
+// compaction is paused. If the background compaction or flush has failed, no
+// more compactions run, so the background error is returned.
+func (t *LSMTree) WaitForCompactions() error {
+	if t.readOnly {
+		return nil
+	}
+
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	for t.shouldCompact() {
+		if compacted, err := t.compactOnce(); err != nil {
+			return fmt.Errorf("failed to compact sstables: %w", err)
+		} else if !compacted {
+			break
+		}
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	if t.bgErr != nil {
+		return fmt.Errorf("background error: %w", t.bgErr)
+	}
+	return nil
+}
+
+// CompactRange flushes the MemTable and compacts SSTables containing the keys
+// in range [start, end). With LeveledStrategy the keys are moved level by level
+// to the last level, with SizeTieredStrategy the tables are merged into one.
+// The nil start and end mean the range is not bounded, so CompactRange(nil, nil)
+// compacts all SSTables.
+func (t *LSMTree) CompactRange(start, end []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+
+	if err := t.flush(); err != nil {
+		return fmt.Errorf("failed to flush memtable: %w", err)
+	}
+
+	t.compactMu.Lock()
+	defer t.compactMu.Unlock()
+
+	for {
+		t.mu.RLock()
+		v := t.version
+		t.mu.RUnlock()
+
+		c := t.compactionStrategy().pickRange(t, v, start, end)
+		if c == nil {
+			return nil
+		}
+
+		if err := t.runCompaction(c); err != nil {
+			return fmt.Errorf("failed to compact level %d: %w", c.level, err)
+		}
+	}
+}
+
+// runCompactions runs the background compaction goroutine until the tree is closed.
+func (t *LSMTree) runCompactions() {
+	defer t.bgWg.Done()
+
+	ticker := time.NewTicker(compactionCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.closing:
+			return
+		case <-t.compactionCh:
+		case <-ticker.C:
+		}
+
+		t.compactMu.Lock()
+		for !t.isClosing() && t.shouldCompact() {
+			compacted, err := t.compactOnce()
+			if err != nil {
+				t.mu.Lock()
+				t.bgErr = fmt.Errorf("failed to compact sstables: %w", err)
+				t.mu.Unlock()
+			}
+			if err != nil || !compacted {
+				break
+			}
+		}
+		t.compactMu.Unlock()
+	}
+}
+
+// triggerCompaction wakes up the compaction goroutine.
+func (t *LSMTree) triggerCompaction() {
+	select {
+	case t.compactionCh <- struct{}{}:
+	default:
+	}
+}
+
+// isClosing returns true if the tree is being closed.
+func (t *LSMTree) isClosing() bool {
+	select {
+	case <-t.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// shouldCompact asks the scheduler whether the compaction must run.
+func (t *LSMTree) shouldCompact() bool {
+	if atomic.LoadInt32(&t.compactionPaused) == 1 {
+		return false
+	}
+
+	t.mu.RLock()
+	v, bgErr := t.version, t.bgErr
+	t.mu.RUnlock()
+
+	if bgErr != nil {
+		return false
+	}
+
+	score := t.compactionStrategy().score(t, v)
+	return t.scheduler.ShouldCompact(CompactionState{
+		SsTableNum:             v.tableNum(),
+		SsTableNumberThreshold: t.ssTableNumberThreshold,
+		Score:                  score,
+		IdleTime:               time.Since(time.Unix(0, atomic.LoadInt64(&t.lastWriteTime))),
+	})
+}
+
+// levelMaxSize returns the size limit of the level in bytes, level must be above 0.
+func (t *LSMTree) levelMaxSize(level int) int {
+	size := t.levelBaseSize
+	for i := 1; i < level; i++ {
+		size *= t.levelSizeMultiplier
+	}
+	return size
+}
+
+// compactOnce runs the compaction picked by the strategy.
+// Returns false if there is nothing to compact. The caller must hold compactMu.
+func (t *LSMTree) compactOnce() (bool, error) {
+	t.mu.RLock()
+	v := t.version
+	t.mu.RUnlock()
+
+	c := t.compactionStrategy().pick(t, v)
+	if c == nil {
+		return false, nil
+	}
+
+	if err := t.runCompaction(c); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// compaction describes the tables merged by the single compaction.
+type compaction struct {
+	// level is the level of inputs.
+	level int
+	// inputs are the tables of the level ordered from the oldest to the newest,
+	// they are newer than the overlaps.
+	inputs []*tableMeta
+	// outputLevel is the level of the merged tables.
+	outputLevel int
+	// overlaps are the tables of the output level overlapping the inputs,
+	// they are replaced by the merged tables. It is always empty if the output
+	// level is the level of inputs.
+	overlaps []*tableMeta
+	// maxOutputSize is the size after which the next output table is started,
+	// 0 means all records are written into a single table.
+	maxOutputSize int
+	// obsoleteRangeDels are the range tombstones dropped without merging any
+	// table, as no table contains the records deleted by them.
+	obsoleteRangeDels rangeTombstones
+}
+
+// runCompaction merges the inputs with the overlapping tables of the output
+// level and replaces them with the result, or just drops the obsolete range
+// tombstones if there are no inputs. The single input is just moved to
+// the output level if there are no overlapping tables, no range tombstones
+// may delete its records and it has no records to drop in the last level,
+// including the expired values.
+// The caller must hold compactMu.
+func (t *LSMTree) runCompaction(c *compaction) error {
+	edit := &versionEdit{}
+	if len(c.inputs) == 0 {
+		for _, r := range c.obsoleteRangeDels {
+			edit.deleteRangeTombstone(r.seq)
+		}
+		if err := t.installVersion(edit); err != nil {
+			return err
+		}
+
+		t.mu.Lock()
+		t.compactionStats.PurgedRangeTombstones += len(edit.deletedRangeDels)
+		t.mu.Unlock()
+		return nil
+	}
+
+	for _, m := range c.inputs {
+		edit.deleteTable(c.level, m.id)
+	}
+
+	// the snapshots created later see only the newest versions of the keys.
+	// The records deleted by the range tombstones of the MemTables are
+	// dropped by the compactions after the flush.
+	t.mu.RLock()
+	v := t.version
+	options := mergeOptions{
+		snapshots: t.snapshots.clone(),
+		rangeDels: v.rangeDelIndex,
+		bottommost: func(key []byte) bool {
+			return c.bottommost(v, key)
+		},
+		now: time.Now().UnixNano(),
+	}
+	t.mu.RUnlock()
+
+	if len(c.inputs) == 1 && len(c.overlaps) == 0 && c.outputLevel != c.level &&
+		!v.rangeDelIndex.overlaps(c.inputs[0].smallest, c.inputs[0].largest) &&
+		!(c.outputLevel == levelNum-1 && (c.inputs[0].hasGarbage(options.snapshots.oldest()) ||
+			c.inputs[0].hasExpired(options.now))) {
+		edit.addTable(c.outputLevel, c.inputs[0])
+		return t.installVersion(edit)
+	}
+
+	its := make([]recordIterator, 0, len(c.inputs)+1)
+	defer func() {
+		for _, it := range its {
+			_ = it.close()
+		}
+	}()
+
+	if len(c.overlaps) > 0 {
+		for _, m := range c.overlaps {
+			edit.deleteTable(c.outputLevel, m.id)
+		}
+
+		it, err := newConcatIterator(t.tableCache, c.overlaps)
+		if err != nil {
+			return fmt.Errorf("failed to instantiate iterator for level %d: %w", c.outputLevel, err)
+		}
+		its = append(its, it)
+	}
+
+	for _, m := range c.inputs {
+		it, err := newTableIterator(t.tableCache, m.id)
+		if err != nil {
+			return fmt.Errorf("failed to instantiate iterator for sstable %d: %w", m.id, err)
+		}
+		its = append(its, it)
+	}
+
+	output := &compactionOutput{t: t, maxSize: c.maxOutputSize}
+	stats, err := merge(its, output, options)
+	if err != nil {
+		output.abort()
+		return fmt.Errorf("failed to merge sstables: %w", err)
+	}
+
+	tables, err := output.finish()
+	if err != nil {
+		output.abort()
+		return fmt.Errorf("failed to finish output: %w", err)
+	}
+	for _, m := range tables {
+		edit.addTable(c.outputLevel, m)
+	}
+
+	// the merged records deleted by the range tombstone are dropped if no
+	// snapshot is taken before the deletion, so it is dropped too when no
+	// table outside the compaction contains such records.
+	for _, r := range v.rangeDels {
+		if options.snapshots.visible(0, r.seq) || !c.overlapsRange(r) {
+			continue
+		}
+
+		covers, err := t.coversOutside(v, c, r)
+		if err != nil {
+			return err
+		}
+		if !covers {
+			edit.deleteRangeTombstone(r.seq)
+		}
+	}
+
+	if err := t.installVersion(edit); err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.compactionStats.PurgedTombstones += stats.purged
+	t.compactionStats.ExpiredValues += stats.expired
+	t.compactionStats.PurgedRangeTombstones += len(edit.deletedRangeDels)
+	t.mu.Unlock()
+	return nil
+}
+
+// includes returns true if the table is merged by the compaction.
+func (c *compaction) includes(m *tableMeta) bool {
+	for _, tables := range [][]*tableMeta{c.inputs, c.overlaps} {
+		for _, input := range tables {
+			if input.id == m.id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bottommost returns true if no table of the version outside the compaction
+// may contain the older records of the key. The tables of the shallower levels
+// are newer than the inputs, except the tables of level 0, which are checked
+// if the inputs are from level 0.
+func (c *compaction) bottommost(v *version, key []byte) bool {
+	for level := c.level; level < levelNum; level++ {
+		for _, m := range v.levels[level] {
+			if m.overlaps(key, key) && !c.includes(m) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// overlapsRange returns true if any merged table overlaps the range of the tombstone.
+func (c *compaction) overlapsRange(r rangeTombstone) bool {
+	for _, tables := range [][]*tableMeta{c.inputs, c.overlaps} {
+		for _, m := range tables {
+			if r.overlaps(m.smallest, m.largest) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// coversOutside returns true if any table of the version outside the compaction
+// contains the records deleted by the range tombstone.
+func (t *LSMTree) coversOutside(v *version, c *compaction, r rangeTombstone) (bool, error) {
+	for _, tables := range v.levels {
+		for _, m := range tables {
+			if !r.overlaps(m.smallest, m.largest) || c.includes(m) {
+				continue
+			}
+
+			if covers, err := t.coversTable(m, r); err != nil || covers {
+				return covers, err
+			}
+		}
+	}
+	return false, nil
+}
+
+// coversTable returns true if the table contains the records deleted by the
+// range tombstone.
+func (t *LSMTree) coversTable(m *tableMeta, r rangeTombstone) (bool, error) {
+	// the cursor sees only the records written before the tombstone.
+	cursor, err := newSsTableCursor(t.tableCache, m.id, r.seq-1)
+	if err != nil {
+		return false, fmt.Errorf("failed to create cursor for sstable %d: %w", m.id, err)
+	}
+	err = cursor.seek(r.start)
+	covers := err == nil && cursor.valid() && bytes.Compare(cursor.key(), r.end) < 0
+	if closeErr := cursor.close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to search in sstable %d: %w", m.id, err)
+	}
+	return covers, nil
+}
+
+// installVersion commits the edit to the MANIFEST and applies it to the current
+// version. The MANIFEST is written and synced under manifestMu only, so neither
+// the lookups nor the writes wait for it. The tables which are not in the new
+// version are deleted once no lookup reads them, see releaseVersion.
+func (t *LSMTree) installVersion(edit *versionEdit) error {
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+
+	t.mu.RLock()
+	previous := t.version
+	edit.nextFileNumber, edit.lastSequence = t.nextFileNumber, t.lastSequence
+	t.mu.RUnlock()
+
+	if err := appendEdit(t.manifest, edit); err != nil {
+		return fmt.Errorf("failed to commit version edit: %w", err)
+	}
+	v := previous.apply(edit, edit.nextFileNumber)
+	v.ref()
+
+	t.mu.Lock()
+	t.version = v
+	t.mu.Unlock()
+
+	if err := t.releaseVersion(previous); err != nil {
+		return err
+	}
+	return t.rollManifest(v)
+}
+
+// rollManifest replaces the MANIFEST whose edits after the snapshot pass
+// maxManifestSize with the new one starting with the snapshot of the version,
+// so Open does not replay all the edits since the tree was opened.
+// The caller must hold manifestMu.
+func (t *LSMTree) rollManifest(v *version) error {
+	info, err := t.manifest.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", t.manifest.Name(), err)
+	}
+	if info.Size()-t.manifestSnapshotSize < t.maxManifestSize {
+		return nil
+	}
+
+	number := t.newFileNumber()
+	t.mu.RLock()
+	snapshot := *v
+	snapshot.nextFileNumber, snapshot.lastSequence = t.nextFileNumber, t.lastSequence
+	t.mu.RUnlock()
+
+	manifest, err := createManifest(t.dbDir, number, &snapshot)
+	if err != nil {
+		return fmt.Errorf("failed to create manifest: %w", err)
+	}
+
+	// the new MANIFEST is current, the left old one is deleted by Open.
+	previous := t.manifest
+	t.manifest = manifest
+	if info, err = manifest.Stat(); err != nil {
+		return fmt.Errorf("failed to stat %s: %w", manifest.Name(), err)
+	}
+	t.manifestSnapshotSize = info.Size()
+	if err := previous.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", previous.Name(), err)
+	}
+	if err := os.Remove(previous.Name()); err != nil {
+		return fmt.Errorf("failed to remove file %s: %w", previous.Name(), err)
+	}
+	return nil
+}
+
+// releaseVersion drops the reference of the version and deletes the tables
+// which are not in any referenced version. The tables left by the crash before
+// the deletion are deleted by Open.
+func (t *LSMTree) releaseVersion(v *version) error {
+	for _, m := range v.unref() {
+		t.tableCache.evict(m.id)
+		if err := deleteSsTable(t.dbDir, strconv.Itoa(m.id)+"-"); err != nil {
+			return fmt.Errorf("failed to delete sstable %d: %w", m.id, err)
+		}
+	}
+	return nil
+}
+
+// compactionOutput writes the merged records into SSTables, starting the new
+// table when the current one reaches the max size. The versions of the same
+// key are written into the same table, so the tables do not overlap.
+type compactionOutput struct {
+	t       *LSMTree
+	maxSize int
+	id      int
+	writer  *ssTableWriter
+	tables  []*tableMeta
+}
+
+// write writes the record into the current table.
+func (o *compactionOutput) write(e entry) error {
+	if o.writer != nil && o.maxSize > 0 && o.writer.dataPos >= o.maxSize && !bytes.Equal(e.key, o.writer.largest) {
+		if err := o.finishTable(); err != nil {
+			return err
+		}
+	}
+
+	if o.writer == nil {
+		o.id = o.t.newFileNumber()
+		writer, err := newSsTableWriter(o.t.dbDir, o.id, o.t.ssTableOptions())
+		if err != nil {
+			return fmt.Errorf("failed to create sstable writer: %w", err)
+		}
+		o.writer = writer
+	}
+
+	return o.writer.write(e)
+}
+
+// finishTable syncs and closes the current table.
+func (o *compactionOutput) finishTable() error {
+	if err := o.writer.sync(); err != nil {
+		return fmt.Errorf("failed to sync sstable %d: %w", o.id, err)
+	}
+
+	if err := o.writer.close(); err != nil {
+		return fmt.Errorf("failed to close sstable %d: %w", o.id, err)
+	}
+
+	o.tables = append(o.tables, o.writer.meta(o.id))
+	o.writer = nil
+	return nil
+}
+
+// finish finishes the current table and returns all written tables. The
+// directory is synced, so the tables are durable before they are installed.
+func (o *compactionOutput) finish() ([]*tableMeta, error) {
+	if o.writer != nil {
+		if err := o.finishTable(); err != nil {
+			return nil, err
+		}
+	}
+	if len(o.tables) > 0 {
+		if err := syncDir(o.t.dbDir); err != nil {
+			return nil, err
+		}
+	}
+	return o.tables, nil
+}
+
+// abort removes all tables written by the failed compaction.
+func (o *compactionOutput) abort() {
+	if o.writer != nil {
+		o.writer.abort()
+		o.writer = nil
+	}
+	for _, m := range o.tables {
+		_ = deleteSsTable(o.t.dbDir, strconv.Itoa(m.id)+"-")
+	}
+	o.tables = nil
+}
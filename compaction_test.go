@@ -0,0 +1,540 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestThresholdScheduler(t *testing.T) {
+	cases := []struct {
+		idleInterval time.Duration
+		state        CompactionState
+		expected     bool
+	}{
+		{0, CompactionState{Score: 1}, true},
+		{0, CompactionState{Score: 0.5, IdleTime: time.Hour}, false},
+		{time.Minute, CompactionState{Score: 0.5, IdleTime: time.Hour}, true},
+		{time.Minute, CompactionState{Score: 0.5, IdleTime: time.Second}, false},
+		{time.Minute, CompactionState{Score: 0, IdleTime: time.Hour}, false},
+	}
+
+	for _, c := range cases {
+		actual := NewThresholdScheduler(c.idleInterval).ShouldCompact(c.state)
+		if actual != c.expected {
+			t.Fatalf("ShouldCompact idleInterval=%v state=%+v expected=%v, actual=%v",
+				c.idleInterval, c.state, c.expected, actual)
+		}
+	}
+}
+
+func TestLSMTree_PauseCompactions(t *testing.T) {
+	tree, close := prepareTree(t, CompactionScheduler(NewThresholdScheduler(0)))
+	defer close()
+
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 200)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if num := levelTableNum(tree, 0); num < tree.ssTableNumberThreshold {
+		t.Fatalf("paused compaction expected level 0 sstable num >= %d, actual num=%d", tree.ssTableNumberThreshold, num)
+	}
+
+	tree.ResumeCompactions()
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if num := levelTableNum(tree, 0); num >= tree.ssTableNumberThreshold {
+		t.Fatalf("resumed compaction expected level 0 sstable num < %d, actual num=%d", tree.ssTableNumberThreshold, num)
+	}
+	checkRange(t, tree, 0, 200)
+}
+
+func TestLSMTree_WaitForCompactionsError(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir, MemTableSizeThreshold(100), SsTableNumberThreshold(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree.Close() }()
+
+	// the tables overlap each other, so they are merged instead of being moved.
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 100)
+	putRange(t, tree, 0, 100)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the background compaction fails to read the removed table.
+	tree.mu.RLock()
+	m := tree.version.levels[0][0]
+	tree.mu.RUnlock()
+	tree.tableCache.evict(m.id)
+	if err := os.Remove(path.Join(dbDir, strconv.Itoa(m.id)+"-"+ssTableFileName)); err != nil {
+		t.Fatal(err)
+	}
+	tree.ResumeCompactions()
+	for failed := false; !failed; time.Sleep(time.Millisecond) {
+		tree.mu.RLock()
+		failed = tree.bgErr != nil
+		tree.mu.RUnlock()
+	}
+
+	if err := tree.WaitForCompactions(); err == nil {
+		t.Fatalf("WaitForCompactions expected to report the background error")
+	}
+}
+
+func TestLSMTree_CompactRange(t *testing.T) {
+	tree, close := prepareTree(t, CompactionScheduler(NewThresholdScheduler(0)))
+	defer close()
+
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 200)
+
+	if err := tree.CompactRange([]byte("key-0150"), []byte("key-0160")); err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.RLock()
+	for level := 0; level < levelNum-1; level++ {
+		if tables := tree.version.overlappingTables(level, []byte("key-0150"), []byte("key-0160")); len(tables) != 0 {
+			t.Fatalf("CompactRange expected no tables of level %d in range, actual num=%d", level, len(tables))
+		}
+	}
+	tree.mu.RUnlock()
+	checkRange(t, tree, 0, 200)
+
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if num := levelTableNum(tree, levelNum-1); num == 0 || num != tableNum(tree) {
+		t.Fatalf("CompactRange expected all tables in the last level, actual num=%d of %d", num, tableNum(tree))
+	}
+	checkRange(t, tree, 0, 200)
+}
+
+func TestLSMTree_LeveledCompaction(t *testing.T) {
+	tree, close := prepareTree(t,
+		CompactionScheduler(NewThresholdScheduler(0)),
+		LevelBaseSize(2000),
+		LevelSizeMultiplier(2),
+		SsTableTargetSize(500),
+	)
+	defer close()
+
+	for round := 0; round < 3; round++ {
+		putRange(t, tree, 0, 300)
+	}
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree.mu.RLock()
+	v := tree.version
+	tree.mu.RUnlock()
+	for level := 1; level < levelNum; level++ {
+		tables := v.levels[level]
+		for i := 1; i < len(tables); i++ {
+			if bytes.Compare(tables[i-1].largest, tables[i].smallest) >= 0 {
+				t.Fatalf("level %d tables %d and %d overlap", level, tables[i-1].id, tables[i].id)
+			}
+		}
+		if level < levelNum-1 && v.levelSize(level) >= tree.levelMaxSize(level) {
+			t.Fatalf("level %d size %d exceeds limit %d", level, v.levelSize(level), tree.levelMaxSize(level))
+		}
+	}
+	if len(v.levels[1]) == 0 || len(v.levels[2]) == 0 {
+		t.Fatalf("expected tables in levels 1 and 2, actual num=%d and %d", len(v.levels[1]), len(v.levels[2]))
+	}
+	checkRange(t, tree, 0, 300)
+}
+
+func TestLSMTree_SizeTieredCompaction(t *testing.T) {
+	tree, close := prepareTree(t,
+		CompactionScheduler(NewThresholdScheduler(0)),
+		CompactionStrategy(SizeTieredStrategy),
+	)
+	defer close()
+
+	for round := 0; round < 3; round++ {
+		putRange(t, tree, 0, 300)
+		if err := tree.Delete([]byte("key-0100")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.flush(); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.WaitForCompactions(); err != nil {
+			t.Fatal(err)
+		}
+
+		tree.mu.RLock()
+		v := tree.version
+		tree.mu.RUnlock()
+		if num := v.tableNum(); num != len(v.levels[0]) {
+			t.Fatalf("size-tiered compaction expected all tables in level 0, actual num=%d of %d", len(v.levels[0]), num)
+		}
+		if score := (sizeTieredStrategy{}).score(tree, v); score >= 1 {
+			t.Fatalf("size-tiered compaction expected score < 1, actual score=%v", score)
+		}
+	}
+
+	if _, ok, err := tree.Get([]byte("key-0100")); err != nil || ok {
+		t.Fatalf("Get deleted key expected ok=false, actual ok=%v err=%v", ok, err)
+	}
+	checkRange(t, tree, 0, 100)
+	checkRange(t, tree, 101, 300)
+
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if num := levelTableNum(tree, 0); num != 1 {
+		t.Fatalf("CompactRange expected single table, actual num=%d", num)
+	}
+	checkRange(t, tree, 101, 300)
+}
+
+func TestLSMTree_PurgeTombstones(t *testing.T) {
+	tree, close := prepareTree(t)
+	defer close()
+
+	deleteRange := func(from, to int) {
+		t.Helper()
+		for i := from; i < to; i++ {
+			if err := tree.Delete([]byte(fmt.Sprintf("key-%04d", i))); err != nil {
+				t.Fatalf("Delete error: %s", err)
+			}
+		}
+	}
+
+	putRange(t, tree, 0, 50)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tombstones are kept while the snapshot taken before them is alive.
+	snapshot := tree.NewSnapshot()
+	deleteRange(0, 25)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if purged := tree.CompactionStats().PurgedTombstones; purged != 0 {
+		t.Fatalf("the tombstones needed by the snapshot must be kept, actual purged=%d", purged)
+	}
+	if value, ok, err := snapshot.Get([]byte("key-0000")); err != nil || !ok || string(value) != "key-0000" {
+		t.Fatalf("snapshot Get expected value=key-0000, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	snapshot.Release()
+
+	deleteRange(0, 25)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if purged := tree.CompactionStats().PurgedTombstones; purged < 25 {
+		t.Fatalf("the bottommost tombstones must be purged, expected purged>=25, actual %d", purged)
+	}
+	if records := recordNum(t, tree); records != 25 {
+		t.Fatalf("the tombstones and the shadowed values must be dropped, expected 25 records, actual %d", records)
+	}
+	for i := 0; i < 25; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if _, ok, err := tree.Get(key); err != nil || ok {
+			t.Fatalf("Get key=%s expected the deleted key, actual ok=%v err=%v", key, ok, err)
+		}
+	}
+	checkRange(t, tree, 25, 50)
+}
+
+func TestLSMTree_PurgeBottomTombstones(t *testing.T) {
+	tree, close := prepareTree(t, MemTableSizeThreshold(defaultMemTableThreshold))
+	defer close()
+	tree.PauseCompactions()
+
+	// the deleted keys and their tombstones are in the same table, which is
+	// moved to the last level without overlapping any table.
+	putRange(t, tree, 0, 50)
+	snapshot := tree.NewSnapshot()
+	for i := 0; i < 25; i++ {
+		if err := tree.Delete([]byte(fmt.Sprintf("key-%04d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if records := recordNum(t, tree); records != 75 {
+		t.Fatalf("the records needed by the snapshot must be kept, expected 75 records, actual %d", records)
+	}
+	tree.mu.RLock()
+	bottom := len(tree.version.levels[levelNum-1])
+	tree.mu.RUnlock()
+	if bottom != tableNum(tree) {
+		t.Fatalf("all tables expected to be in the last level, actual %d of %d", bottom, tableNum(tree))
+	}
+
+	// the release of the snapshot schedules the compaction of the last level.
+	snapshot.Release()
+	tree.ResumeCompactions()
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if purged := tree.CompactionStats().PurgedTombstones; purged != 25 {
+		t.Fatalf("the bottommost tombstones must be purged, expected purged=25, actual %d", purged)
+	}
+	if records := recordNum(t, tree); records != 25 {
+		t.Fatalf("the tombstones and the shadowed values must be dropped, expected 25 records, actual %d", records)
+	}
+	checkRange(t, tree, 25, 50)
+
+	// without the snapshot the table with the tombstones is merged instead
+	// of being moved into the last level.
+	putRange(t, tree, 50, 60)
+	for i := 50; i < 60; i++ {
+		if err := tree.Delete([]byte(fmt.Sprintf("key-%04d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if records := recordNum(t, tree); records != 25 {
+		t.Fatalf("the tombstones and the shadowed values must be dropped, expected 25 records, actual %d", records)
+	}
+}
+
+func TestLSMTree_PurgeRangeTombstones(t *testing.T) {
+	tree, close := prepareTree(t, MemTableSizeThreshold(defaultMemTableThreshold))
+	defer close()
+
+	putRange(t, tree, 0, 50)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	snapshot := tree.NewSnapshot()
+	if err := tree.DeleteRange([]byte("key-0010"), []byte("key-0020")); err != nil {
+		t.Fatal(err)
+	}
+	// the tables written later overlap all tables with the deleted keys.
+	rewrite := func() {
+		t.Helper()
+		putRange(t, tree, 0, 10)
+		putRange(t, tree, 20, 50)
+		if err := tree.CompactRange(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rewrite()
+	if purged := tree.CompactionStats().PurgedRangeTombstones; purged != 0 {
+		t.Fatalf("the range tombstone needed by the snapshot must be kept, actual purged=%d", purged)
+	}
+	snapshot.Release()
+
+	rewrite()
+	if purged := tree.CompactionStats().PurgedRangeTombstones; purged != 1 {
+		t.Fatalf("the range tombstone must be purged, actual purged=%d", purged)
+	}
+	tree.mu.RLock()
+	rangeDels := len(tree.version.rangeDels)
+	tree.mu.RUnlock()
+	if rangeDels != 0 {
+		t.Fatalf("the purged range tombstone must be removed from the version, actual %d", rangeDels)
+	}
+	if records := recordNum(t, tree); records != 40 {
+		t.Fatalf("the deleted keys must be dropped, expected 40 records, actual %d", records)
+	}
+	checkRange(t, tree, 0, 10)
+	checkRange(t, tree, 20, 50)
+	if _, ok, err := tree.Get([]byte("key-0010")); err != nil || ok {
+		t.Fatalf("Get expected the deleted key, actual ok=%v err=%v", ok, err)
+	}
+}
+
+func TestLeveledStrategy_StaleRangeTombstoneScore(t *testing.T) {
+	tree, close := prepareTree(t, TableCacheSize(100))
+	defer close()
+
+	putRange(t, tree, 0, 50)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	tree.PauseCompactions()
+	if err := tree.DeleteRange([]byte("key-0010"), []byte("key-0020")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the score is computed on every tick, so it must not read the tables.
+	tree.tableCache.close()
+	tree.mu.RLock()
+	v := tree.version
+	tree.mu.RUnlock()
+	if score := (leveledStrategy{}).score(tree, v); score < 1 {
+		t.Fatalf("the stale range tombstone requires the compaction, actual score=%f", score)
+	}
+	if cached := tree.tableCache.lru.Len(); cached != 0 {
+		t.Fatalf("score must not open the tables, actual cached=%d", cached)
+	}
+	if c := (leveledStrategy{}).pick(tree, v); c == nil || len(c.inputs) == 0 {
+		t.Fatalf("pick expected the compaction of the covered table")
+	}
+}
+
+func TestLSMTree_PurgeBottomRangeTombstones(t *testing.T) {
+	tree, close := prepareTree(t, MemTableSizeThreshold(defaultMemTableThreshold))
+	defer close()
+
+	// the deleted keys are only in the last level, no later table overlaps them.
+	putRange(t, tree, 0, 50)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	tree.PauseCompactions()
+	snapshot := tree.NewSnapshot()
+	if err := tree.DeleteRange([]byte("key-0010"), []byte("key-0020")); err != nil {
+		t.Fatal(err)
+	}
+	// the range tombstone of the keys which were never written.
+	if err := tree.DeleteRange([]byte("key-0100"), []byte("key-0200")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	rangeDelNum := func() int {
+		tree.mu.RLock()
+		defer tree.mu.RUnlock()
+		return len(tree.version.rangeDels)
+	}
+
+	tree.ResumeCompactions()
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if purged := tree.CompactionStats().PurgedRangeTombstones; purged != 0 || rangeDelNum() != 2 {
+		t.Fatalf("the range tombstones needed by the snapshot must be kept, actual purged=%d", purged)
+	}
+
+	// the release of the snapshot schedules the compaction of the last level.
+	snapshot.Release()
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if purged := tree.CompactionStats().PurgedRangeTombstones; purged != 2 || rangeDelNum() != 0 {
+		t.Fatalf("the range tombstones must be purged, expected purged=2, actual purged=%d", purged)
+	}
+	if records := recordNum(t, tree); records != 40 {
+		t.Fatalf("the deleted keys must be dropped, expected 40 records, actual %d", records)
+	}
+	checkRange(t, tree, 0, 10)
+	checkRange(t, tree, 20, 50)
+	if _, ok, err := tree.Get([]byte("key-0010")); err != nil || ok {
+		t.Fatalf("Get expected the deleted key, actual ok=%v err=%v", ok, err)
+	}
+}
+
+func TestVersion_applyLevel0(t *testing.T) {
+	edit := &versionEdit{}
+	for id := 1; id <= 4; id++ {
+		edit.addTable(0, &tableMeta{id: id})
+	}
+	v := (&version{}).apply(edit, 5)
+
+	edit = &versionEdit{}
+	edit.deleteTable(0, 2)
+	edit.deleteTable(0, 3)
+	edit.addTable(0, &tableMeta{id: 5})
+	v = v.apply(edit, 6)
+
+	edit = &versionEdit{}
+	edit.addTable(0, &tableMeta{id: 6})
+	v = v.apply(edit, 7)
+
+	expected := []int{1, 5, 4, 6}
+	if len(v.levels[0]) != len(expected) {
+		t.Fatalf("apply expected %d tables, actual %d", len(expected), len(v.levels[0]))
+	}
+	for i, id := range expected {
+		if v.levels[0][i].id != id {
+			t.Fatalf("apply expected table %d at %d, actual %d", id, i, v.levels[0][i].id)
+		}
+	}
+}
+
+func putRange(t *testing.T, tree *LSMTree, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+	}
+}
+
+func checkRange(t *testing.T, tree *LSMTree, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		value, ok, err := tree.Get(key)
+		if err != nil || !ok || !bytes.Equal(key, value) {
+			t.Fatalf("Get key=%s, unexpected ok=%v value=%s err=%v", key, ok, value, err)
+		}
+	}
+}
+
+func levelTableNum(tree *LSMTree, level int) int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return len(tree.version.levels[level])
+}
+
+func recordNum(t *testing.T, tree *LSMTree) int {
+	t.Helper()
+	tree.mu.RLock()
+	v := tree.version
+	tree.mu.RUnlock()
+
+	num := 0
+	for _, tables := range v.levels {
+		for _, m := range tables {
+			it, err := newTableIterator(tree.tableCache, m.id)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for ; it.hasNext(); num++ {
+				if _, err := it.next(); err != nil {
+					t.Fatal(err)
+				}
+			}
+			_ = it.close()
+		}
+	}
+	return num
+}
+
+func tableNum(tree *LSMTree) int {
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	return tree.version.tableNum()
+}
@@ -7,7 +7,7 @@ import (
 )
 
 // @Author KHighness
-// @Update 2023-09-19
+// @Update 2026-10-16
 
 // encode encodes key and value and writes it to the specified writer.
 // Returns the number of bytes written and error if occurred.
@@ -50,31 +50,41 @@ func encode(key []byte, value []byte, w io.Writer) (int, error) {
 // The function must be compatible with decode: encode(decode(v)) == v.
 func decode(r io.Reader) ([]byte, []byte, error) {
 	var encodedEntryLen [8]byte
-	if _, err := r.Read(encodedEntryLen[:]); err != nil {
+	if _, err := io.ReadFull(r, encodedEntryLen[:]); err != nil {
+		// io.EOF is returned only if there are no more entries.
 		return nil, nil, err
 	}
 
 	entryLen := decodeInt(encodedEntryLen[:])
-	encodedEntry := make([]byte, entryLen)
-	n, err := r.Read(encodedEntry)
-	if err != nil {
-		return nil, nil, err
+	if entryLen < 8 {
+		return nil, nil, fmt.Errorf("the file is corrupted, bad entry length %d", entryLen)
+	}
+	// the reader of the known size, such as bytes.Reader, cannot hold the longer entry.
+	if sized, ok := r.(interface{ Len() int }); ok && entryLen > sized.Len() {
+		return nil, nil, io.ErrUnexpectedEOF
 	}
 
-	if n < entryLen {
-		return nil, nil, fmt.Errorf("the file is corrupted, failed to read entry")
+	encodedEntry := make([]byte, entryLen)
+	if _, err := io.ReadFull(r, encodedEntry); err != nil {
+		if err == io.EOF {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		return nil, nil, err
 	}
 
 	keyLen := decodeInt(encodedEntry[0:8])
+	if keyLen < 0 || keyLen > entryLen-8 {
+		return nil, nil, fmt.Errorf("the file is corrupted, bad key length %d", keyLen)
+	}
 	keyEnd := 8 + keyLen
 	key := encodedEntry[8:keyEnd]
 
 	if keyEnd == len(encodedEntry) {
-		return key, nil, err
+		return key, nil, nil
 	}
 
 	value := encodedEntry[keyEnd:]
-	return key, value, err
+	return key, value, nil
 }
 
 // encodeKeyOffset encodes key offset and writes it to the given writer.
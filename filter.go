@@ -0,0 +1,117 @@
+package lsmtree
+
+import (
+	"encoding/binary"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// maxBloomProbes is the max number of the bloom filter probes, the filter
+	// with more probes is considered to be written by the newer version.
+	maxBloomProbes = 30
+)
+
+// bloomFilter is the bloom filter of SSTable keys. The last byte contains
+// the number of probes.
+type bloomFilter []byte
+
+// newBloomFilter creates the bloom filter of the keys with the given hashes.
+func newBloomFilter(hashes []uint32, bitsPerKey int) bloomFilter {
+	// the number of probes is bitsPerKey * ln(2), which minimizes the false positive rate.
+	probes := bitsPerKey * 69 / 100
+	if probes < 1 {
+		probes = 1
+	} else if probes > maxBloomProbes {
+		probes = maxBloomProbes
+	}
+
+	// for the small number of keys the false positive rate is too high.
+	bits := len(hashes) * bitsPerKey
+	if bits < 64 {
+		bits = 64
+	}
+	bytes := (bits + 7) / 8
+	bits = bytes * 8
+
+	filter := make(bloomFilter, bytes+1)
+	for _, h := range hashes {
+		// the double hashing generates the probes from a single hash.
+		delta := h>>17 | h<<15
+		for i := 0; i < probes; i++ {
+			pos := h % uint32(bits)
+			filter[pos/8] |= 1 << (pos % 8)
+			h += delta
+		}
+	}
+	filter[bytes] = byte(probes)
+
+	return filter
+}
+
+// mayContain returns false if the key is definitely not in the table.
+func (f bloomFilter) mayContain(key []byte) bool {
+	if len(f) < 2 {
+		return true
+	}
+
+	probes := int(f[len(f)-1])
+	if probes > maxBloomProbes {
+		return true
+	}
+
+	bits := uint32((len(f) - 1) * 8)
+	h := bloomHash(key)
+	delta := h>>17 | h<<15
+	for i := 0; i < probes; i++ {
+		pos := h % bits
+		if f[pos/8]&(1<<(pos%8)) == 0 {
+			return false
+		}
+		h += delta
+	}
+
+	return true
+}
+
+// bloomHash returns the hash of the key used by the bloom filter,
+// it is similar to murmur hash.
+func bloomHash(key []byte) uint32 {
+	const seed, m = 0xbc9f1d34, 0xc6a4a793
+
+	h := seed ^ uint32(len(key))*m
+	for ; len(key) >= 4; key = key[4:] {
+		h += binary.LittleEndian.Uint32(key)
+		h *= m
+		h ^= h >> 16
+	}
+
+	switch len(key) {
+	case 3:
+		h += uint32(key[2]) << 16
+		fallthrough
+	case 2:
+		h += uint32(key[1]) << 8
+		fallthrough
+	case 1:
+		h += uint32(key[0])
+		h *= m
+		h ^= h >> 24
+	}
+
+	return h
+}
+
+// readFilter reads the bloom filter of the specific SSTable. Returns nil
+// if the table has no filter, which is the case of the tables written by
+// the older version or with the disabled filter.
+func readFilter(dbDir string, index int) (bloomFilter, error) {
+	r, err := openSsTable(dbDir, index)
+	if err != nil {
+		return nil, err
+	}
+	defer r.close()
+
+	return r.readFilter()
+}
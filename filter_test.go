@@ -0,0 +1,71 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestBloomFilter(t *testing.T) {
+	if !bloomFilter(nil).mayContain([]byte("a")) {
+		t.Fatalf("empty filter must contain any key")
+	}
+
+	const keyNum = 10000
+	hashes := make([]uint32, 0, keyNum)
+	for i := 0; i < keyNum; i++ {
+		hashes = append(hashes, bloomHash([]byte(fmt.Sprintf("key-%d", i))))
+	}
+	filter := newBloomFilter(hashes, defaultBloomBitsPerKey)
+
+	for i := 0; i < keyNum; i++ {
+		if key := []byte(fmt.Sprintf("key-%d", i)); !filter.mayContain(key) {
+			t.Fatalf("filter must contain key=%s", key)
+		}
+	}
+
+	falsePositives := 0
+	for i := keyNum; i < 2*keyNum; i++ {
+		if filter.mayContain([]byte(fmt.Sprintf("key-%d", i))) {
+			falsePositives++
+		}
+	}
+	if rate := float64(falsePositives) / keyNum; rate > 0.02 {
+		t.Fatalf("filter expected false positive rate <= 0.02, actual rate=%v", rate)
+	}
+}
+
+func TestLSMTree_BloomFilter(t *testing.T) {
+	for _, bitsPerKey := range []int{0, defaultBloomBitsPerKey} {
+		tree, close := prepareTree(t, BloomBitsPerKey(bitsPerKey))
+
+		putRange(t, tree, 0, 100)
+		if err := tree.flush(); err != nil {
+			t.Fatal(err)
+		}
+
+		tree.mu.RLock()
+		v := tree.version
+		tree.mu.RUnlock()
+		for _, tables := range v.levels {
+			for _, m := range tables {
+				filter, err := readFilter(tree.dbDir, m.id)
+				if err != nil || (filter != nil) != (bitsPerKey > 0) || !bytes.Equal(filter, m.filter) {
+					t.Fatalf("bitsPerKey=%d, readFilter table %d, unexpected filter err=%v", bitsPerKey, m.id, err)
+				}
+				if m.smallest != nil && !m.mayContain(tree.dbDir, m.smallest) {
+					t.Fatalf("table %d filter must contain key=%s", m.id, m.smallest)
+				}
+			}
+		}
+
+		checkRange(t, tree, 0, 100)
+		if _, ok, err := tree.Get([]byte("missing")); err != nil || ok {
+			t.Fatalf("Get missing key expected ok=false, actual ok=%v err=%v", ok, err)
+		}
+		close()
+	}
+}
@@ -2,6 +2,4 @@ module lsmtree
 
 go 1.16
 
-require (
-    github.com/Khighness/gokit v0.0.0-20230916122935-604a87422717 // indirect
-)
+require github.com/Khighness/gokit v0.0.0-20230916122935-604a87422717
@@ -0,0 +1,842 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// internalIterator iterates over a single sorted source of records:
+// the MemTable or one SSTable. Only the newest version of the key
+// visible to the iterator is visited. Deleted keys are also visited,
+// but the value for them is nil.
+type internalIterator interface {
+	// valid returns true if the iterator is positioned at a record.
+	valid() bool
+	// key returns the key of the current record.
+	key() []byte
+	// value returns the value of the current record.
+	value() []byte
+	// seq returns the sequence number of the current record.
+	seq() uint64
+	// expiresAt returns the expiration time of the current record, see entry.
+	expiresAt() int64
+	// first moves to the first record.
+	first() error
+	// last moves to the last record.
+	last() error
+	// seek moves to the first record with the key greater or equal to the given key.
+	seek(key []byte) error
+	// seekForPrev moves to the last record with the key less or equal to the given key.
+	seekForPrev(key []byte) error
+	// next moves to the next record.
+	next() error
+	// prev moves to the previous record.
+	prev() error
+	// close closes all associated resources.
+	close() error
+}
+
+// entry is a key-value pair, the nil value marks the deleted key.
+// seq is the sequence number of the record.
+type entry struct {
+	key   []byte
+	value []byte
+	seq   uint64
+	// rangeDelete marks the deletion of the keys in range [key, value)
+	// in WriteBatch.
+	rangeDelete bool
+	// expiresAt is the time in Unix nanoseconds when the value expires,
+	// 0 if the value does not expire.
+	expiresAt int64
+}
+
+// kind returns the kind of the record.
+func (e entry) kind() recordKind {
+	if e.rangeDelete {
+		return kindRangeDelete
+	} else if e.value == nil {
+		return kindDelete
+	} else if e.expiresAt != 0 {
+		return kindExpiringPut
+	}
+	return kindPut
+}
+
+// expired returns true if the value has expired by the given time in Unix
+// nanoseconds. The expired value is treated as the deletion of the key.
+func (e entry) expired(now int64) bool {
+	return e.expiresAt != 0 && e.expiresAt <= now
+}
+
+// sliceCursor is internalIterator over the sorted slice of entries.
+type sliceCursor struct {
+	entries []entry
+	pos     int
+}
+
+func (c *sliceCursor) valid() bool {
+	return c.pos >= 0 && c.pos < len(c.entries)
+}
+
+func (c *sliceCursor) key() []byte {
+	return c.entries[c.pos].key
+}
+
+func (c *sliceCursor) value() []byte {
+	return c.entries[c.pos].value
+}
+
+func (c *sliceCursor) seq() uint64 {
+	return c.entries[c.pos].seq
+}
+
+func (c *sliceCursor) expiresAt() int64 {
+	return c.entries[c.pos].expiresAt
+}
+
+func (c *sliceCursor) first() error {
+	c.pos = 0
+	return nil
+}
+
+func (c *sliceCursor) last() error {
+	c.pos = len(c.entries) - 1
+	return nil
+}
+
+func (c *sliceCursor) seek(key []byte) error {
+	c.pos = sort.Search(len(c.entries), func(i int) bool {
+		return bytes.Compare(c.entries[i].key, key) >= 0
+	})
+	return nil
+}
+
+func (c *sliceCursor) seekForPrev(key []byte) error {
+	c.pos = sort.Search(len(c.entries), func(i int) bool {
+		return bytes.Compare(c.entries[i].key, key) > 0
+	}) - 1
+	return nil
+}
+
+func (c *sliceCursor) next() error {
+	c.pos++
+	return nil
+}
+
+func (c *sliceCursor) prev() error {
+	c.pos--
+	return nil
+}
+
+func (c *sliceCursor) close() error {
+	return nil
+}
+
+// visibleEntries returns the newest version of every key whose sequence number
+// is not greater than the given one. The versions of the key must be ordered
+// from the newest to the oldest.
+func visibleEntries(entries []entry, seq uint64) []entry {
+	visible := make([]entry, 0, len(entries))
+	for _, e := range entries {
+		if e.seq > seq {
+			continue
+		}
+		if n := len(visible); n > 0 && bytes.Equal(visible[n-1].key, e.key) {
+			continue
+		}
+		visible = append(visible, e)
+	}
+	return visible
+}
+
+// newMemTableCursor creates a cursor over the keys of MemTable in range [start, end)
+// as of the write with the given sequence number. The red-black tree can be traversed
+// only forwards, so the keys are copied into a slice.
+func newMemTableCursor(mt *memTable, start, end []byte, seq uint64) *sliceCursor {
+	entries := make([]entry, 0)
+	for it := mt.iterator(); it.hasNext(); {
+		e := it.next()
+		if bytes.Compare(e.key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(e.key, end) >= 0 {
+			break
+		}
+		entries = append(entries, e)
+	}
+
+	return &sliceCursor{entries: visibleEntries(entries, seq), pos: -1}
+}
+
+// ssTableCursor is internalIterator over SSTable. The current data block
+// is decoded into memory, so it can be traversed in both directions.
+type ssTableCursor struct {
+	// cache shares the reader of the table, it is released by close.
+	cache  *tableCache
+	cached *cachedTable
+	table  *ssTableReader
+	// readSeq is the sequence number of the last write visible to the cursor.
+	readSeq uint64
+	// blockIndex is the index of the loaded block, -1 if none.
+	blockIndex int
+	// block contains the visible records of the loaded block, it may be
+	// empty if all records of the block are newer than the cursor.
+	block sliceCursor
+}
+
+// newSsTableCursor creates a cursor over SSTable with the given index as of
+// the write with the given sequence number. The reader is taken from the cache.
+func newSsTableCursor(cache *tableCache, index int, seq uint64) (*ssTableCursor, error) {
+	cached, err := cache.acquire(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sstable %d: %w", index, err)
+	}
+
+	return &ssTableCursor{
+		cache:      cache,
+		cached:     cached,
+		table:      cached.reader,
+		readSeq:    seq,
+		blockIndex: -1,
+		block:      sliceCursor{pos: -1},
+	}, nil
+}
+
+// loadBlock reads and decodes the block with the given index.
+func (c *ssTableCursor) loadBlock(blockIndex int) error {
+	if blockIndex < 0 || blockIndex >= len(c.table.blocks) {
+		c.blockIndex, c.block = -1, sliceCursor{pos: -1}
+		return nil
+	}
+	if blockIndex == c.blockIndex {
+		return nil
+	}
+
+	entries, err := c.table.readDataBlock(blockIndex)
+	if err != nil {
+		return err
+	}
+
+	c.blockIndex, c.block = blockIndex, sliceCursor{entries: visibleEntries(entries, c.readSeq), pos: -1}
+	return nil
+}
+
+func (c *ssTableCursor) valid() bool {
+	return c.blockIndex >= 0 && c.block.valid()
+}
+
+func (c *ssTableCursor) key() []byte {
+	return c.block.key()
+}
+
+func (c *ssTableCursor) value() []byte {
+	return c.block.value()
+}
+
+func (c *ssTableCursor) seq() uint64 {
+	return c.block.seq()
+}
+
+func (c *ssTableCursor) expiresAt() int64 {
+	return c.block.expiresAt()
+}
+
+func (c *ssTableCursor) first() error {
+	if err := c.loadBlock(0); err != nil {
+		return err
+	}
+	_ = c.block.first()
+	if !c.block.valid() {
+		return c.nextBlock()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) last() error {
+	if err := c.loadBlock(len(c.table.blocks) - 1); err != nil {
+		return err
+	}
+	_ = c.block.last()
+	if !c.block.valid() {
+		return c.prevBlock()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) seek(key []byte) error {
+	blockIndex := c.table.findBlock(key)
+	if blockIndex < 0 {
+		return c.first()
+	}
+
+	if err := c.loadBlock(blockIndex); err != nil {
+		return err
+	}
+	_ = c.block.seek(key)
+	if !c.block.valid() {
+		return c.nextBlock()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) seekForPrev(key []byte) error {
+	if err := c.loadBlock(c.table.findBlock(key)); err != nil {
+		return err
+	}
+	_ = c.block.seekForPrev(key)
+	if !c.block.valid() {
+		return c.prevBlock()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) next() error {
+	_ = c.block.next()
+	if !c.block.valid() {
+		return c.nextBlock()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) prev() error {
+	_ = c.block.prev()
+	if !c.block.valid() {
+		return c.prevBlock()
+	}
+	return nil
+}
+
+// nextBlock moves to the first record of the next block, skipping the blocks
+// without visible records.
+func (c *ssTableCursor) nextBlock() error {
+	for c.blockIndex >= 0 && !c.block.valid() {
+		if err := c.loadBlock(c.blockIndex + 1); err != nil {
+			return err
+		}
+		_ = c.block.first()
+	}
+	return nil
+}
+
+// prevBlock moves to the last record of the previous block, skipping the blocks
+// without visible records.
+func (c *ssTableCursor) prevBlock() error {
+	for c.blockIndex >= 0 && !c.block.valid() {
+		if err := c.loadBlock(c.blockIndex - 1); err != nil {
+			return err
+		}
+		_ = c.block.last()
+	}
+	return nil
+}
+
+func (c *ssTableCursor) close() error {
+	if c.cached != nil {
+		c.cache.release(c.cached)
+		c.cached = nil
+	}
+	return nil
+}
+
+// concatCursor is internalIterator over the non-overlapping SSTables of the
+// level ordered by keys, it moves from one table to another. Only the current
+// table is open, so the level does not hold the reader of every table.
+type concatCursor struct {
+	cache   *tableCache
+	readSeq uint64
+	tables  []*tableMeta
+	// index is the index of the current table.
+	index int
+	// current is the cursor of the table with index currentIndex, nil if none.
+	current      *ssTableCursor
+	currentIndex int
+}
+
+func (c *concatCursor) valid() bool {
+	return c.current != nil && c.current.valid()
+}
+
+func (c *concatCursor) key() []byte {
+	return c.current.key()
+}
+
+func (c *concatCursor) value() []byte {
+	return c.current.value()
+}
+
+func (c *concatCursor) seq() uint64 {
+	return c.current.seq()
+}
+
+func (c *concatCursor) expiresAt() int64 {
+	return c.current.expiresAt()
+}
+
+func (c *concatCursor) first() error {
+	return c.forwardFrom(0, internalIterator.first)
+}
+
+func (c *concatCursor) last() error {
+	return c.backwardFrom(len(c.tables)-1, internalIterator.last)
+}
+
+func (c *concatCursor) seek(key []byte) error {
+	index := sort.Search(len(c.tables), func(i int) bool {
+		return bytes.Compare(c.tables[i].largest, key) >= 0
+	})
+	return c.forwardFrom(index, func(tc internalIterator) error {
+		return tc.seek(key)
+	})
+}
+
+func (c *concatCursor) seekForPrev(key []byte) error {
+	index := sort.Search(len(c.tables), func(i int) bool {
+		return bytes.Compare(c.tables[i].smallest, key) > 0
+	}) - 1
+	return c.backwardFrom(index, func(tc internalIterator) error {
+		return tc.seekForPrev(key)
+	})
+}
+
+func (c *concatCursor) next() error {
+	if err := c.current.next(); err != nil || c.current.valid() {
+		return err
+	}
+	return c.forwardFrom(c.index+1, internalIterator.first)
+}
+
+func (c *concatCursor) prev() error {
+	if err := c.current.prev(); err != nil || c.current.valid() {
+		return err
+	}
+	return c.backwardFrom(c.index-1, internalIterator.last)
+}
+
+// forwardFrom applies the movement to the tables starting from the given one,
+// until the cursor of the table becomes valid.
+func (c *concatCursor) forwardFrom(index int, move func(internalIterator) error) error {
+	for c.index = index; c.index < len(c.tables); c.index++ {
+		if err := c.open(c.index); err != nil {
+			return err
+		}
+		if err := move(c.current); err != nil || c.current.valid() {
+			return err
+		}
+	}
+	return c.close()
+}
+
+// backwardFrom applies the movement to the tables starting from the given one
+// in the reverse order, until the cursor of the table becomes valid.
+func (c *concatCursor) backwardFrom(index int, move func(internalIterator) error) error {
+	for c.index = index; c.index >= 0; c.index-- {
+		if err := c.open(c.index); err != nil {
+			return err
+		}
+		if err := move(c.current); err != nil || c.current.valid() {
+			return err
+		}
+	}
+	return c.close()
+}
+
+// open makes the table with the given index current, the previous one is closed.
+func (c *concatCursor) open(index int) error {
+	if c.current != nil && c.currentIndex == index {
+		return nil
+	}
+	if err := c.close(); err != nil {
+		return err
+	}
+
+	tc, err := newSsTableCursor(c.cache, c.tables[index].id, c.readSeq)
+	if err != nil {
+		return fmt.Errorf("failed to create cursor for sstable %d: %w", c.tables[index].id, err)
+	}
+	c.current, c.currentIndex = tc, index
+	return nil
+}
+
+func (c *concatCursor) close() error {
+	if c.current == nil {
+		return nil
+	}
+	err := c.current.close()
+	c.current = nil
+	return err
+}
+
+// Iterator iterates over the live key-value pairs of the tree in key order.
+// It merges MemTable and all SSTables: if the key exists in several sources,
+// the newest one wins, and deleted and expired keys are skipped.
+type Iterator struct {
+	// sources are ordered from the newest to the oldest.
+	sources []internalIterator
+	// rangeDels are the range tombstones visible to the iterator, the keys
+	// deleted by them are skipped.
+	rangeDels rangeDelView
+	// now is the time of the iterator creation in Unix nanoseconds, the values
+	// expired by then are skipped.
+	now int64
+
+	// start and end are the bounds of the iteration: [start, end).
+	// The nil means no bound.
+	start, end []byte
+
+	// t is the tree and version is its version referenced by the iterator
+	// until it is closed, so the tables are not deleted while they are read.
+	t       *LSMTree
+	version *version
+
+	// reverse is true if the sources are positioned for the backward movement.
+	reverse bool
+
+	key, value []byte
+	valid      bool
+}
+
+// NewIterator creates an iterator over the keys in range [start, end).
+// The nil start means iterating from the first key and the nil end
+// means iterating up to the last key. The iterator is positioned at
+// the first key in the range, it must be closed after use.
+// The iterator sees the state of the tree at the moment of its
+// creation, the later changes are not visible.
+func (t *LSMTree) NewIterator(start, end []byte) (*Iterator, error) {
+	t.mu.RLock()
+	it := t.newIterator(start, end, t.lastSequence)
+	t.mu.RUnlock()
+
+	if err := it.openTables(); err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// newIterator creates an iterator over the keys in range [start, end) as of
+// the write with the given sequence number. The MemTables are copied and the
+// current version is referenced, so the SSTables are opened by openTables
+// without mu and are not deleted by the compaction meanwhile. The caller must
+// hold mu.
+func (t *LSMTree) newIterator(start, end []byte, seq uint64) *Iterator {
+	it := &Iterator{
+		sources:   []internalIterator{newMemTableCursor(t.mt, start, end, seq)},
+		rangeDels: t.rangeTombstones(seq),
+		now:       time.Now().UnixNano(),
+		start:     start,
+		end:       end,
+		t:         t,
+		version:   t.version,
+	}
+	it.version.ref()
+
+	if t.imm != nil {
+		it.sources = append(it.sources, newMemTableCursor(t.imm, start, end, seq))
+	}
+	return it
+}
+
+// openTables opens the SSTables of the referenced version containing keys in
+// the range and positions the iterator at the first key. The caller must not
+// hold mu.
+func (it *Iterator) openTables() error {
+	seq, v := it.rangeDels.seq, it.version
+	l0 := v.levels[0]
+	for i := len(l0) - 1; i >= 0; i-- {
+		if !l0[i].overlaps(it.start, it.end) {
+			continue
+		}
+
+		c, err := newSsTableCursor(it.t.tableCache, l0[i].id, seq)
+		if err != nil {
+			return fmt.Errorf("failed to create cursor for sstable %d: %w", l0[i].id, err)
+		}
+		it.sources = append(it.sources, c)
+	}
+
+	// the tables of the deeper levels are opened one by one while moving.
+	for level := 1; level < levelNum; level++ {
+		it.sources = append(it.sources, &concatCursor{
+			cache:   it.t.tableCache,
+			readSeq: seq,
+			tables:  v.overlappingTables(level, it.start, it.end),
+		})
+	}
+
+	return it.First()
+}
+
+// Valid returns true if the iterator is positioned at a key-value pair.
+func (it *Iterator) Valid() bool {
+	return it.valid
+}
+
+// Key returns the key at the current position.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value at the current position.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// First moves to the first live key in the range.
+func (it *Iterator) First() error {
+	if it.start != nil {
+		return it.Seek(it.start)
+	}
+
+	if err := it.forEachSource(internalIterator.first); err != nil {
+		return err
+	}
+	return it.findForward()
+}
+
+// Last moves to the last live key in the range.
+func (it *Iterator) Last() error {
+	if it.end != nil {
+		return it.seekBefore(it.end)
+	}
+
+	if err := it.forEachSource(internalIterator.last); err != nil {
+		return err
+	}
+	return it.findBackward()
+}
+
+// Seek moves to the first live key which is greater or equal to the given key.
+func (it *Iterator) Seek(key []byte) error {
+	if bytes.Compare(key, it.start) < 0 {
+		key = it.start
+	}
+
+	err := it.forEachSource(func(source internalIterator) error {
+		return source.seek(key)
+	})
+	if err != nil {
+		return err
+	}
+	return it.findForward()
+}
+
+// SeekForPrev moves to the last live key which is less or equal to the given key.
+func (it *Iterator) SeekForPrev(key []byte) error {
+	if it.end != nil && bytes.Compare(key, it.end) >= 0 {
+		return it.seekBefore(it.end)
+	}
+
+	err := it.forEachSource(func(source internalIterator) error {
+		return source.seekForPrev(key)
+	})
+	if err != nil {
+		return err
+	}
+	return it.findBackward()
+}
+
+// Next moves to the next live key.
+func (it *Iterator) Next() error {
+	if !it.valid {
+		return nil
+	}
+
+	if it.reverse {
+		key := it.key
+		err := it.forEachSource(func(source internalIterator) error {
+			return source.seek(key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := it.skip(it.key, internalIterator.next); err != nil {
+		return err
+	}
+	return it.findForward()
+}
+
+// Prev moves to the previous live key.
+func (it *Iterator) Prev() error {
+	if !it.valid {
+		return nil
+	}
+
+	if !it.reverse {
+		key := it.key
+		err := it.forEachSource(func(source internalIterator) error {
+			return source.seekForPrev(key)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := it.skip(it.key, internalIterator.prev); err != nil {
+		return err
+	}
+	return it.findBackward()
+}
+
+// Close closes all associated resources.
+func (it *Iterator) Close() error {
+	it.valid = false
+	defer func() {
+		// the iterator does not fail if the obsolete tables are not deleted,
+		// they are deleted by the next Open.
+		if it.version != nil {
+			_ = it.t.releaseVersion(it.version)
+			it.version = nil
+		}
+	}()
+
+	for _, source := range it.sources {
+		if err := source.close(); err != nil {
+			return fmt.Errorf("failed to close source: %w", err)
+		}
+	}
+	return nil
+}
+
+// seekBefore moves to the last live key which is less than the given key.
+func (it *Iterator) seekBefore(key []byte) error {
+	err := it.forEachSource(func(source internalIterator) error {
+		return source.seekForPrev(key)
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := it.skip(key, internalIterator.prev); err != nil {
+		return err
+	}
+	return it.findBackward()
+}
+
+// forEachSource applies the movement to all sources.
+func (it *Iterator) forEachSource(move func(internalIterator) error) error {
+	for _, source := range it.sources {
+		if err := move(source); err != nil {
+			return fmt.Errorf("failed to move source: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// skip applies the movement to the sources positioned at the given key.
+func (it *Iterator) skip(key []byte, move func(internalIterator) error) error {
+	for _, source := range it.sources {
+		if source.valid() && bytes.Equal(source.key(), key) {
+			if err := move(source); err != nil {
+				return fmt.Errorf("failed to move source: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// live returns true if the current record of the source is the live value:
+// it is neither deleted, nor expired, nor deleted by the range tombstones.
+func (it *Iterator) live(source internalIterator) bool {
+	e := entry{value: source.value(), expiresAt: source.expiresAt()}
+	return e.value != nil && !e.expired(it.now) && !it.rangeDels.covers(source.key(), source.seq())
+}
+
+// findForward moves to the smallest live key among the sources, skipping
+// the keys which are shadowed by newer sources, deleted or expired.
+func (it *Iterator) findForward() error {
+	it.reverse = false
+	for {
+		var current internalIterator
+		for _, source := range it.sources {
+			// sources are ordered from the newest, so older equal keys lose.
+			if source.valid() && (current == nil || bytes.Compare(source.key(), current.key()) < 0) {
+				current = source
+			}
+		}
+
+		if current == nil || (it.end != nil && bytes.Compare(current.key(), it.end) >= 0) {
+			it.key, it.value, it.valid = nil, nil, false
+			return nil
+		}
+
+		if it.live(current) {
+			it.key, it.value, it.valid = current.key(), current.value(), true
+			return nil
+		}
+
+		if err := it.skip(current.key(), internalIterator.next); err != nil {
+			return err
+		}
+	}
+}
+
+// findBackward moves to the largest live key among the sources, skipping
+// the keys which are shadowed by newer sources, deleted or expired.
+func (it *Iterator) findBackward() error {
+	it.reverse = true
+	for {
+		var current internalIterator
+		for _, source := range it.sources {
+			// sources are ordered from the newest, so older equal keys lose.
+			if source.valid() && (current == nil || bytes.Compare(source.key(), current.key()) > 0) {
+				current = source
+			}
+		}
+
+		if current == nil || bytes.Compare(current.key(), it.start) < 0 {
+			it.key, it.value, it.valid = nil, nil, false
+			return nil
+		}
+
+		if it.live(current) {
+			it.key, it.value, it.valid = current.key(), current.value(), true
+			return nil
+		}
+
+		if err := it.skip(current.key(), internalIterator.prev); err != nil {
+			return err
+		}
+	}
+}
+
+// ScanPrefix calls fn for every live key starting with the given prefix in
+// ascending key order. The scan stops as soon as fn returns false.
+func (t *LSMTree) ScanPrefix(prefix []byte, fn func(key, value []byte) bool) error {
+	it, err := t.NewIterator(prefix, prefixSuccessor(prefix))
+	if err != nil {
+		return fmt.Errorf("failed to create iterator: %w", err)
+	}
+
+	for it.Valid() && fn(it.Key(), it.Value()) {
+		if err := it.Next(); err != nil {
+			_ = it.Close()
+			return fmt.Errorf("failed to advance iterator: %w", err)
+		}
+	}
+
+	return it.Close()
+}
+
+// prefixSuccessor returns the smallest key which is greater than all keys
+// starting with the given prefix, or nil if there is no such key.
+func prefixSuccessor(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+
+	return nil
+}
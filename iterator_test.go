@@ -0,0 +1,281 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestIterator(t *testing.T) {
+	tree, close := prepareTree(t)
+	defer close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%03d", i))
+		if err := tree.Put(key, []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+	}
+	for i := 0; i < 100; i += 3 {
+		if err := tree.Delete([]byte(fmt.Sprintf("%03d", i))); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+	}
+	for i := 0; i < 100; i += 5 {
+		key := []byte(fmt.Sprintf("%03d", i))
+		if err := tree.Put(key, []byte(fmt.Sprintf("n%d", i))); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+	}
+
+	cases := []struct {
+		start, end []byte
+		from, to   int
+	}{
+		{nil, nil, 0, 100},
+		{[]byte("010"), []byte("020"), 10, 20},
+		{[]byte("095"), nil, 95, 100},
+		{nil, []byte("005"), 0, 5},
+		{[]byte("2"), nil, 100, 100},
+	}
+
+	for _, c := range cases {
+		it, err := tree.NewIterator(c.start, c.end)
+		if err != nil {
+			t.Fatalf("NewIterator error: %s", err)
+		}
+
+		for i := c.from; i < c.to; i++ {
+			if i%3 == 0 && i%5 != 0 {
+				continue
+			}
+
+			value := fmt.Sprintf("v%d", i)
+			if i%5 == 0 {
+				value = fmt.Sprintf("n%d", i)
+			}
+			key := fmt.Sprintf("%03d", i)
+			if !it.Valid() || !bytes.Equal(it.Key(), []byte(key)) || !bytes.Equal(it.Value(), []byte(value)) {
+				t.Fatalf("Iterator expected key=%s value=%s, actual valid=%v key=%s value=%s",
+					key, value, it.Valid(), it.Key(), it.Value())
+			}
+			if err := it.Next(); err != nil {
+				t.Fatalf("Next error: %s", err)
+			}
+		}
+
+		if it.Valid() {
+			t.Fatalf("Iterator expected the end, actual key=%s", it.Key())
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("Close error: %s", err)
+		}
+	}
+}
+
+func prepareTree(t *testing.T, options ...func(*LSMTree)) (*LSMTree, func()) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+
+	options = append([]func(*LSMTree){
+		SparseKeyDistance(4),
+		MemTableSizeThreshold(100),
+		SsTableNumberThreshold(3),
+	}, options...)
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+
+	return tree, func() {
+		if err := tree.Close(); err != nil {
+			panic(fmt.Errorf("failed to close: %w", err))
+		}
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}
+}
+
+func TestLSMTree_ScanPrefix(t *testing.T) {
+	tree, close := prepareTree(t)
+	defer close()
+
+	for _, tenant := range []string{"a", "b", "c"} {
+		for i := 0; i < 30; i++ {
+			key := []byte(fmt.Sprintf("%s/t/%02d", tenant, i))
+			if err := tree.Put(key, key); err != nil {
+				t.Fatalf("Put error: %s", err)
+			}
+		}
+	}
+	if err := tree.Delete([]byte("b/t/07")); err != nil {
+		t.Fatalf("Delete error: %s", err)
+	}
+
+	var keys []string
+	err := tree.ScanPrefix([]byte("b/"), func(key, value []byte) bool {
+		keys = append(keys, string(key))
+		return true
+	})
+	if err != nil {
+		t.Fatalf("ScanPrefix error: %s", err)
+	}
+	if len(keys) != 29 || keys[0] != "b/t/00" || keys[7] != "b/t/08" || keys[28] != "b/t/29" {
+		t.Fatalf("ScanPrefix unexpected keys: %v", keys)
+	}
+
+	visited := 0
+	err = tree.ScanPrefix([]byte("c/"), func(key, value []byte) bool {
+		visited++
+		return visited < 5
+	})
+	if err != nil {
+		t.Fatalf("ScanPrefix error: %s", err)
+	}
+	if visited != 5 {
+		t.Fatalf("ScanPrefix expected to stop after 5 keys, actual visited=%d", visited)
+	}
+}
+
+func TestIterator_Reverse(t *testing.T) {
+	tree, close := prepareTree(t)
+	defer close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+	}
+	for i := 0; i < 100; i += 2 {
+		if err := tree.Delete([]byte(fmt.Sprintf("%03d", i))); err != nil {
+			t.Fatalf("Delete error: %s", err)
+		}
+	}
+
+	it, err := tree.NewIterator([]byte("010"), []byte("090"))
+	if err != nil {
+		t.Fatalf("NewIterator error: %s", err)
+	}
+	defer it.Close()
+
+	expectKey := func(expected string) {
+		t.Helper()
+		if expected == "" {
+			if it.Valid() {
+				t.Fatalf("Iterator expected the end, actual key=%s", it.Key())
+			}
+			return
+		}
+		if !it.Valid() || string(it.Key()) != expected {
+			t.Fatalf("Iterator expected key=%s, actual valid=%v key=%s", expected, it.Valid(), it.Key())
+		}
+	}
+
+	if err := it.Last(); err != nil {
+		t.Fatalf("Last error: %s", err)
+	}
+	for i := 89; i >= 11; i -= 2 {
+		expectKey(fmt.Sprintf("%03d", i))
+		if err := it.Prev(); err != nil {
+			t.Fatalf("Prev error: %s", err)
+		}
+	}
+	expectKey("")
+
+	if err := it.SeekForPrev([]byte("050")); err != nil {
+		t.Fatalf("SeekForPrev error: %s", err)
+	}
+	expectKey("049")
+	if err := it.Next(); err != nil {
+		t.Fatalf("Next error: %s", err)
+	}
+	expectKey("051")
+	if err := it.Prev(); err != nil {
+		t.Fatalf("Prev error: %s", err)
+	}
+	expectKey("049")
+
+	if err := it.SeekForPrev([]byte("100")); err != nil {
+		t.Fatalf("SeekForPrev error: %s", err)
+	}
+	expectKey("089")
+	if err := it.SeekForPrev([]byte("010")); err != nil {
+		t.Fatalf("SeekForPrev error: %s", err)
+	}
+	expectKey("")
+	if err := it.Seek([]byte("000")); err != nil {
+		t.Fatalf("Seek error: %s", err)
+	}
+	expectKey("011")
+}
+
+func TestIterator_ReferencedVersion(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	// the overwritten keys make the tables overlap, so they are merged.
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 100)
+	putRange(t, tree, 0, 100)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the tables are opened without mu, the referenced version keeps them
+	// until the iterator is closed.
+	tree.mu.RLock()
+	it := tree.newIterator(nil, nil, tree.lastSequence)
+	tree.mu.RUnlock()
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := it.openTables(); err != nil {
+		t.Fatalf("openTables error: %s", err)
+	}
+	count := 0
+	for ; it.Valid(); count++ {
+		if !bytes.Equal(it.Key(), it.Value()) {
+			t.Fatalf("iterator key=%s, unexpected value=%s", it.Key(), it.Value())
+		}
+		if err := it.Next(); err != nil {
+			t.Fatalf("Next error: %s", err)
+		}
+	}
+	if count != 100 {
+		t.Fatalf("iterator expected 100 keys, actual %d", count)
+	}
+
+	tablesOnDisk := func() int {
+		t.Helper()
+		infos, err := ioutil.ReadDir(tree.dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		num := 0
+		for _, info := range infos {
+			if strings.HasSuffix(info.Name(), "-"+ssTableFileName) {
+				num++
+			}
+		}
+		return num
+	}
+	if tablesOnDisk() <= tableNum(tree) {
+		t.Fatalf("the tables of the referenced version must be kept until the iterator is closed")
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close error: %s", err)
+	}
+	if num := tablesOnDisk(); num != tableNum(tree) {
+		t.Fatalf("the obsolete tables must be deleted after Close, expected %d tables, actual %d", tableNum(tree), num)
+	}
+}
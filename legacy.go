@@ -0,0 +1,145 @@
+package lsmtree
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// The legacy SSTable consists of three files: data, index and sparse index,
+// and optionally the bloom filter file. It is only read, the compaction
+// rewrites it into the SSTable file.
+const (
+	// legacyDataFileName is SSTable data file name. It contains raw data.
+	legacyDataFileName = "data.db"
+	// legacyIndexFileName is SSTable index file name. It contains keys and positions to values in the data file.
+	legacyIndexFileName = "index.db"
+	// legacySparseIndexFileName is SSTable sparse index file name.
+	legacySparseIndexFileName = "sparse.db"
+	// legacyFilterFileName is SSTable bloom filter file name. It contains the filter of all keys.
+	legacyFilterFileName = "filter.db"
+)
+
+// openLegacySsTable opens the legacy SSTable with the given index. The data file
+// is split into blocks by the sparse index.
+func openLegacySsTable(dbDir string, index int) (*ssTableReader, error) {
+	prefix := strconv.Itoa(index) + "-"
+
+	blocks, err := readSparseIndex(dbDir, index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sparse index: %w", err)
+	}
+
+	dataPath := path.Join(dbDir, prefix+legacyDataFileName)
+	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data file %s: %w", dataPath, err)
+	}
+
+	info, err := dataFile.Stat()
+	if err != nil {
+		_ = dataFile.Close()
+		return nil, fmt.Errorf("failed to stat data file %s: %w", dataPath, err)
+	}
+
+	// the blocks are placed one after another.
+	for i := range blocks {
+		if i+1 < len(blocks) {
+			blocks[i].size = blocks[i+1].offset - blocks[i].offset
+		} else {
+			blocks[i].size = int(info.Size()) - blocks[i].offset
+		}
+	}
+
+	return &ssTableReader{
+		file:             dataFile,
+		dataSize:         int(info.Size()),
+		blocks:           blocks,
+		legacyFilterPath: path.Join(dbDir, prefix+legacyFilterFileName),
+	}, nil
+}
+
+// readSparseIndex reads all records of the sparse index of the specific legacy
+// SSTable. Every record splits the data file into blocks which can be read
+// independently.
+func readSparseIndex(dbDir string, index int) ([]blockHandle, error) {
+	prefix := strconv.Itoa(index) + "-"
+
+	sparseIndexPath := path.Join(dbDir, prefix+legacySparseIndexFileName)
+	sparseIndexFile, err := os.OpenFile(sparseIndexPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sparse index file: %w", err)
+	}
+	defer sparseIndexFile.Close()
+
+	var indexFile *os.File
+	defer func() {
+		if indexFile != nil {
+			_ = indexFile.Close()
+		}
+	}()
+
+	blocks := make([]blockHandle, 0)
+	for {
+		key, value, err := decode(sparseIndexFile)
+		if err != nil {
+			if err == io.EOF {
+				return blocks, nil
+			}
+			return nil, fmt.Errorf("failed to read sparse index %s: %w", sparseIndexPath, err)
+		}
+
+		if len(value) >= 16 {
+			_, dataOffset := decodeIntPair(value)
+			blocks = append(blocks, blockHandle{key: key, offset: dataOffset})
+			continue
+		}
+
+		// The sparse index written by the older version contains only the offset
+		// in the index file, so the data offset is read from the index file.
+		if indexFile == nil {
+			indexPath := path.Join(dbDir, prefix+legacyIndexFileName)
+			if indexFile, err = os.OpenFile(indexPath, os.O_RDONLY, 0600); err != nil {
+				return nil, fmt.Errorf("failed to open index file: %w", err)
+			}
+		}
+		if _, err := indexFile.Seek(int64(decodeInt(value)), io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek: %w", err)
+		}
+		_, offset, err := decode(indexFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read index file: %w", err)
+		}
+		blocks = append(blocks, blockHandle{key: key, offset: decodeInt(offset)})
+	}
+}
+
+// deleteLegacySsTable deletes the legacy SSTable: data, index, sparse index and filter files.
+func deleteLegacySsTable(dbDir string, prefix string) error {
+	dataPath := path.Join(dbDir, prefix+legacyDataFileName)
+	if err := os.Remove(dataPath); err != nil {
+		return fmt.Errorf("failed to remove data file %s: %w", dataPath, err)
+	}
+
+	indexPath := path.Join(dbDir, prefix+legacyIndexFileName)
+	if err := os.Remove(indexPath); err != nil {
+		return fmt.Errorf("failed to remove index file %s: %w", indexPath, err)
+	}
+
+	sparseIndexPath := path.Join(dbDir, prefix+legacySparseIndexFileName)
+	if err := os.Remove(sparseIndexPath); err != nil {
+		return fmt.Errorf("failed to remove sparse index file %s: %w", sparseIndexPath, err)
+	}
+
+	filterPath := path.Join(dbDir, prefix+legacyFilterFileName)
+	if err := os.Remove(filterPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove filter file %s: %w", filterPath, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// lockFileName is the name of the file locked by the instance which owns the directory.
+	lockFileName = "LOCK"
+)
+
+var (
+	// lockedDirs are the directories locked by this process. The file lock may not
+	// conflict within the same process on some platforms, so it is checked first.
+	lockedDirs   = make(map[string]bool)
+	lockedDirsMu sync.Mutex
+)
+
+// dirLock is the lock of the database directory, it is held for the lifetime of the tree.
+type dirLock struct {
+	dir  string
+	file *os.File
+	// created is true if the lock file did not exist before lockDir.
+	created bool
+}
+
+// lockDir locks the database directory. Returns ErrLocked if the directory is
+// locked by another instance in this or another process.
+func lockDir(dbDir string) (*dirLock, error) {
+	dir, err := filepath.Abs(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of %s: %w", dbDir, err)
+	}
+
+	lockedDirsMu.Lock()
+	defer lockedDirsMu.Unlock()
+
+	if lockedDirs[dir] {
+		return nil, fmt.Errorf("%w: %s", ErrLocked, dbDir)
+	}
+
+	lockPath := path.Join(dbDir, lockFileName)
+	_, statErr := os.Stat(lockPath)
+	file, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", lockPath, err)
+	}
+
+	if err := lockFile(file); err != nil {
+		_ = file.Close()
+		if err == ErrLocked {
+			return nil, fmt.Errorf("%w: %s", ErrLocked, dbDir)
+		}
+		return nil, fmt.Errorf("failed to lock file %s: %w", lockPath, err)
+	}
+
+	lockedDirs[dir] = true
+	return &dirLock{dir: dir, file: file, created: os.IsNotExist(statErr)}, nil
+}
+
+// unlock releases the lock of the directory. It does nothing if the lock is
+// already released.
+func (l *dirLock) unlock() error {
+	lockedDirsMu.Lock()
+	defer lockedDirsMu.Unlock()
+
+	if l.file == nil {
+		return nil
+	}
+
+	err := unlockFile(l.file)
+	if closeErr := l.file.Close(); err == nil {
+		err = closeErr
+	}
+	delete(lockedDirs, l.dir)
+	l.file = nil
+
+	if err != nil {
+		return fmt.Errorf("failed to unlock directory %s: %w", l.dir, err)
+	}
+	return nil
+}
+
+// discard removes the lock file if it was created by lockDir and releases the
+// lock, so the failed Open leaves the directory as it was. The file is removed
+// while it is locked, so no other instance locks it meanwhile.
+func (l *dirLock) discard() error {
+	if l.created && l.file != nil {
+		lockPath := path.Join(l.dir, lockFileName)
+		if err := os.Remove(lockPath); err != nil {
+			_ = l.unlock()
+			return fmt.Errorf("failed to remove file %s: %w", lockPath, err)
+		}
+	}
+
+	return l.unlock()
+}
@@ -0,0 +1,27 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd
+// +build linux darwin dragonfly freebsd netbsd openbsd
+
+package lsmtree
+
+import (
+	"os"
+	"syscall"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// lockFile takes the exclusive flock of the file without waiting. Returns
+// ErrLocked if the file is locked by another open file description.
+func lockFile(file *os.File) error {
+	err := syscall.Flock(int(file.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err == syscall.EWOULDBLOCK {
+		return ErrLocked
+	}
+	return err
+}
+
+// unlockFile releases the flock of the file.
+func unlockFile(file *os.File) error {
+	return syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,22 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
+
+package lsmtree
+
+import (
+	"os"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// lockFile does nothing on the platforms without flock, only the instances
+// in the same process are excluded.
+func lockFile(file *os.File) error {
+	return nil
+}
+
+// unlockFile does nothing on the platforms without flock.
+func unlockFile(file *os.File) error {
+	return nil
+}
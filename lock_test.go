@@ -0,0 +1,56 @@
+package lsmtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path"
+	"runtime"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestLSMTree_Lock(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dbDir); !errors.Is(err, ErrLocked) {
+		t.Fatalf("Open expected err=%v, actual err=%v", ErrLocked, err)
+	}
+
+	// another process opens the file by itself, so the flock must conflict.
+	if runtime.GOOS == "linux" {
+		file, err := os.OpenFile(path.Join(dbDir, lockFileName), os.O_RDWR, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := lockFile(file); err != ErrLocked {
+			t.Fatalf("lockFile expected err=%v, actual err=%v", ErrLocked, err)
+		}
+		if err := file.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatalf("Open must succeed after Close, err=%v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -3,13 +3,16 @@ package lsmtree
 import (
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"os"
-	"path"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // @Author KHighness
-// @Update 2023-10-01
+// @Update 2026-10-16
 
 const (
 	// MaxKeySize is the maximum allowed key size.
@@ -19,14 +22,23 @@ const (
 )
 
 const (
-	// walFileName is WAL file name.
+	// walFileName is WAL segment file name, it is prefixed by the segment number.
 	walFileName = "wal.db"
+	// defaultWALSegmentSize is default size limit of WAL segment.
+	defaultWALSegmentSize = 4 << 20 // 4MB
+	// defaultMaxManifestSize is default size limit of MANIFEST.
+	defaultMaxManifestSize = 4 << 20 // 4MB
 	// defaultMemTableThreshold is default MemTable memory size threshold.
 	defaultMemTableThreshold = 64000 // 64KB
 	// defaultSparseKeyDistance is default distance between keys in sparse index.
 	defaultSparseKeyDistance = 128
+	// defaultBlockSize is default size of SSTable data block.
+	defaultBlockSize = 4096 // 4KB
 	// defaultSsTableNumberThreshold is default SSTable number threshold.
 	defaultSsTableNumberThreshold = 10
+	// defaultBloomBitsPerKey is default number of bloom filter bits per key,
+	// which gives about 1% false positive rate.
+	defaultBloomBitsPerKey = 10
 )
 
 var (
@@ -38,28 +50,172 @@ var (
 	ErrKeyTooLarge = errors.New("key too large")
 	// ErrKeyRequired represents the value size is larger than MaxValueSize.
 	ErrValueTooLarge = errors.New("value too large")
+	// ErrCorruption represents the data read from the file is corrupted,
+	// the error is returned as CorruptionError which names the file and offset.
+	ErrCorruption = errors.New("data corruption")
+	// ErrLocked represents the directory is used by another instance of the tree.
+	ErrLocked = errors.New("database directory is locked")
+	// ErrReadOnly represents the write to the tree opened by OpenReadOnly.
+	ErrReadOnly = errors.New("database is opened in read-only mode")
+	// ErrSnapshotReleased represents the read from the released snapshot.
+	ErrSnapshotReleased = errors.New("snapshot is released")
+	// ErrConflict represents the transaction conflicts with another write,
+	// the transaction may be retried.
+	ErrConflict = errors.New("transaction conflict")
+	// ErrTxnDone represents the use of the committed or rolled back transaction.
+	ErrTxnDone = errors.New("transaction is already committed or rolled back")
+	// ErrLockTimeout represents the key lock is not acquired in the lock timeout.
+	ErrLockTimeout = errors.New("transaction lock timeout")
+	// ErrDeadlock represents the transactions wait for the key locks of each other.
+	ErrDeadlock = errors.New("transaction deadlock")
+	// ErrClosed represents the repeated Close of the tree.
+	ErrClosed = errors.New("database is already closed")
+	// ErrInvalidRange represents the start of the deleted range is not less than its end.
+	ErrInvalidRange = errors.New("invalid range")
 )
 
 // LSM is log-structure merge-tree implementation for storing data in files.
+// It is safe for concurrent use by multiple goroutines.
 type LSMTree struct {
+	// lastWriteTime is the time of the last write in nanoseconds.
+	// It goes first to be 64-bit aligned for the atomic operations.
+	lastWriteTime int64
+
+	// writeMu serializes the writers, only the writer holding it
+	// may change the WAL, the MemTable and the SSTables.
+	writeMu sync.Mutex
+
+	// queueMu guards queue, the writers waiting for the group commit.
+	queueMu sync.Mutex
+	queue   []*writer
+
+	// syncMode decides when the WAL file is synced, syncInterval is the
+	// interval between the syncs in SyncPeriodic mode.
+	syncMode     SyncMode
+	syncInterval time.Duration
+	// walDirty is true if the WAL file has unsynced writes, lastSyncTime is
+	// the time of the last sync, walSyncs is the number of the syncs.
+	// They are guarded by writeMu.
+	walDirty     bool
+	lastSyncTime time.Time
+	walSyncs     int
+	// syncWg waits for the goroutine syncing the WAL file in SyncPeriodic mode.
+	syncWg sync.WaitGroup
+
+	// mu guards the state shared with the readers and the background
+	// goroutines: wal, mt, imm, bgErr, version and nextFileNumber.
+	// The writer holds it only to apply the changes, so the readers are
+	// not blocked while SSTables are being created or merged.
+	mu sync.RWMutex
+
+	// flushed is signaled when the background flush of imm is finished.
+	flushed *sync.Cond
+
+	// bgWg waits for the background goroutines.
+	bgWg sync.WaitGroup
+
+	// bgErr is the error occurred in the background goroutine,
+	// once it is set the tree refuses all writes.
+	bgErr error
+
+	// closing is closed when the tree is being closed.
+	closing chan struct{}
+
+	// closed is 1 once Close is called.
+	closed int32
+
+	// compactMu serializes the compactions.
+	compactMu sync.Mutex
+
+	// compactionCh wakes up the compaction goroutine.
+	compactionCh chan struct{}
+
+	// compactionPaused is 1 if the background compaction is paused.
+	compactionPaused int32
+
+	// scheduler decides when the background compaction must run.
+	scheduler Scheduler
+
+	// strategy decides which SSTables are merged by the compaction.
+	strategy Strategy
+
 	// dbDir is the path for directory that stored LSM tree files,
 	// it is required to provide dedicated directory for each instance
 	// of the tree.
 	dbDir string
 
-	// wal is file for storing write-ahead log.
-	wal *os.File
+	// lock is the lock of dbDir held until the tree is closed.
+	lock *dirLock
+
+	// readOnly is true if the tree is opened by OpenReadOnly. It does not
+	// change any files and refuses all writes.
+	readOnly bool
+
+	// wal is the current segment of write-ahead log, walNumber is its number
+	// and walSize is its size in bytes.
+	wal       *os.File
+	walNumber int
+	walSize   int64
+
+	// walSegmentSize is the size limit of WAL segment in bytes. If the current
+	// segment passes the limit, the new segment is started.
+	walSegmentSize int
+
+	// walArchiveDir is the directory the obsolete WAL segments are moved into,
+	// they are removed if it is empty.
+	walArchiveDir string
 
 	// mt is memory cache of ssTable.
 	mt *memTable
 
-	// maxSsTableIndex points to the latest created SSTable on the disk.
-	// After MemTable is flushed, thr index is updated.
-	// By default -1
-	maxSsTableIndex int
-
-	// ssTableNum is current number of flushed and merged SSTables in the durable storage.
-	ssTableNum int
+	// imm is the full MemTable which is being flushed in the background,
+	// nil if there is no flush in progress. It is still readable and
+	// its changes are kept in the WAL segments until the flush ends.
+	imm *memTable
+
+	// version is the current set of SSTables in the durable storage.
+	// After MemTable is flushed or SSTables are compacted, the new version
+	// is installed.
+	version *version
+
+	// tableCache keeps the searched SSTables open, tableCacheSize is the max
+	// number of the open tables.
+	tableCache     *tableCache
+	tableCacheSize int
+
+	// manifestMu serializes the version changes, the writes of the version
+	// edits to the manifest and the manifest rolls. The version is changed
+	// under both manifestMu and mu, so it may be read under either of them.
+	manifestMu sync.Mutex
+
+	// manifest is the log of version edits, the edit is committed by
+	// appending it to the manifest. It is guarded by manifestMu.
+	manifest *os.File
+
+	// maxManifestSize is the size limit of the version edits appended to the
+	// manifest after its snapshot in bytes. If the edits pass the limit, the
+	// new manifest starting with the snapshot of the current version replaces
+	// it. manifestSnapshotSize is the size of the snapshot, it is guarded by
+	// manifestMu.
+	maxManifestSize      int64
+	manifestSnapshotSize int64
+
+	// nextFileNumber is the id of the next created SSTable.
+	nextFileNumber int
+
+	// lastSequence is the sequence number of the last write. It is changed
+	// by the writer holding writeMu, so the writer may read it without mu.
+	lastSequence uint64
+	// snapshots are the live snapshots, they are guarded by mu.
+	snapshots snapshotList
+	// txnLocks are the key locks of the pessimistic transactions.
+	txnLocks *keyLocks
+
+	// compactPointers are the largest keys of the last compacted table
+	// of each level, the next compaction of the level starts after it.
+	compactPointers [levelNum][]byte
+	// compactionStats is the work done by the compactions, it is guarded by mu.
+	compactionStats CompactionStats
 
 	// memTableSizeThreshold is threshold of MemTable's memory size in bytes.
 	// If MemTable size in bytes passes the threshold, it must be flushed
@@ -67,11 +223,40 @@ type LSMTree struct {
 	memTableSizeThreshold int
 
 	// ssTableNumberThreshold is threshold of SSTable's disk size in bytes.
-	// If SSTable number passes the threshold, it must be merged to decrease space.
+	// If SSTable number in level 0 passes the threshold, it must be merged to
+	// decrease space. The merge is run by the background compaction goroutine.
+	// With SizeTieredStrategy it is the number of similar tables merged at a time.
 	ssTableNumberThreshold int
 
-	// sparseKeyDistance is distance between keys in sparse index.
+	// levelBaseSize is the size limit of level 1 in bytes. If the size of the
+	// level passes its limit, its tables are merged into the next level.
+	levelBaseSize int
+
+	// levelSizeMultiplier is the ratio between the size limits of the next
+	// and the current levels.
+	levelSizeMultiplier int
+
+	// ssTableTargetSize is the size of SSTable created by the compaction.
+	ssTableTargetSize int
+
+	// sparseKeyDistance is distance between keys in sparse index, which is
+	// the max number of keys in SSTable data block.
 	sparseKeyDistance int
+
+	// blockSize is the size of SSTable data block in bytes. The data block
+	// is read as a whole, so the smaller blocks make point lookups cheaper
+	// and the larger blocks make the index smaller.
+	blockSize int
+
+	// walRecoveryMode decides how the torn and corrupted WAL records are
+	// handled by Open, recoveryStats is the outcome of the recovery.
+	walRecoveryMode WALRecoveryMode
+	recoveryStats   RecoveryStats
+
+	// bloomBitsPerKey is the number of bits per key in SSTable bloom filter.
+	// The filter allows skipping the tables which do not contain the key
+	// without reading them. 0 disables the filter.
+	bloomBitsPerKey int
 }
 
 // MemTableSizeThreshold sets memTableSizeThreshold for LSMTree.
@@ -88,6 +273,34 @@ func SsTableNumberThreshold(ssTableNumberThreshold int) func(*LSMTree) {
 	}
 }
 
+// CompactionStrategy sets the compaction strategy for LSMTree.
+func CompactionStrategy(strategy Strategy) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.strategy = strategy
+	}
+}
+
+// LevelBaseSize sets levelBaseSize for LSMTree.
+func LevelBaseSize(levelBaseSize int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.levelBaseSize = levelBaseSize
+	}
+}
+
+// LevelSizeMultiplier sets levelSizeMultiplier for LSMTree.
+func LevelSizeMultiplier(levelSizeMultiplier int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.levelSizeMultiplier = levelSizeMultiplier
+	}
+}
+
+// SsTableTargetSize sets ssTableTargetSize for LSMTree.
+func SsTableTargetSize(ssTableTargetSize int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.ssTableTargetSize = ssTableTargetSize
+	}
+}
+
 // SparseKeyDistance sets sparseKeyDistance for LSMTree.
 func SparseKeyDistance(sparseKeyDistance int) func(*LSMTree) {
 	return func(t *LSMTree) {
@@ -95,145 +308,514 @@ func SparseKeyDistance(sparseKeyDistance int) func(*LSMTree) {
 	}
 }
 
-// Open opens the database. Only one instance of the tree is allowed to
-// read and write to the directory.
-func Open(dbDir string, options ...func(*LSMTree)) (*LSMTree, error) {
-	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory %s does not exist", dbDir)
+// BlockSize sets blockSize for LSMTree.
+func BlockSize(blockSize int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.blockSize = blockSize
 	}
+}
 
-	walPath := path.Join(dbDir, walFileName)
-	wal, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", walPath, err)
+// BloomBitsPerKey sets bloomBitsPerKey for LSMTree.
+func BloomBitsPerKey(bloomBitsPerKey int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.bloomBitsPerKey = bloomBitsPerKey
 	}
+}
 
-	mt, err := loadMemTable(wal)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load memtable from %s: %w", walPath, err)
+// WALSegmentSize sets walSegmentSize for LSMTree.
+func WALSegmentSize(walSegmentSize int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.walSegmentSize = walSegmentSize
 	}
+}
 
-	ssTableNum, maxSsTableIndex, err := readSsTableMeta(dbDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read sstable meta: %w", err)
+// MaxManifestSize sets maxManifestSize for LSMTree.
+func MaxManifestSize(maxManifestSize int64) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.maxManifestSize = maxManifestSize
+	}
+}
+
+// WALArchiveDir sets walArchiveDir for LSMTree. The directory must be
+// on the same filesystem as the database directory.
+func WALArchiveDir(walArchiveDir string) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.walArchiveDir = walArchiveDir
 	}
+}
 
+// newLSMTree creates the tree with default settings and applies the options.
+func newLSMTree(dbDir string, options []func(*LSMTree)) *LSMTree {
 	t := &LSMTree{
 		dbDir:                  dbDir,
-		wal:                    wal,
-		mt:                     mt,
-		maxSsTableIndex:        maxSsTableIndex,
-		ssTableNum:             ssTableNum,
 		memTableSizeThreshold:  defaultMemTableThreshold,
 		ssTableNumberThreshold: defaultSsTableNumberThreshold,
+		levelBaseSize:          defaultLevelBaseSize,
+		levelSizeMultiplier:    defaultLevelSizeMultiplier,
+		ssTableTargetSize:      defaultSsTableTargetSize,
 		sparseKeyDistance:      defaultSparseKeyDistance,
+		blockSize:              defaultBlockSize,
+		bloomBitsPerKey:        defaultBloomBitsPerKey,
+		walSegmentSize:         defaultWALSegmentSize,
+		maxManifestSize:        defaultMaxManifestSize,
+		lastWriteTime:          time.Now().UnixNano(),
+		closing:                make(chan struct{}),
+		compactionCh:           make(chan struct{}, 1),
+		scheduler:              NewThresholdScheduler(defaultCompactionIdleInterval),
+		syncInterval:           defaultSyncInterval,
+		lastSyncTime:           time.Now(),
+		txnLocks:               newKeyLocks(),
+		tableCacheSize:         defaultTableCacheSize,
 	}
+	t.flushed = sync.NewCond(&t.mu)
 	for _, option := range options {
 		option(t)
 	}
-	return t, nil
+	t.tableCache = newTableCache(dbDir, t.tableCacheSize)
+
+	return t
 }
 
-// Close closes all allocated resources.
-func (t *LSMTree) Close() error {
-	if err := t.wal.Close(); err != nil {
-		return fmt.Errorf("failed to close file %s: %w", t.wal.Name(), err)
+// Open opens the database. Only one instance of the tree is allowed to
+// read and write to the directory, the directory is locked until Close.
+// Returns ErrLocked if the directory is used by another instance.
+func Open(dbDir string, options ...func(*LSMTree)) (_ *LSMTree, err error) {
+	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("directory %s does not exist", dbDir)
 	}
 
-	return nil
-}
+	lock, err := lockDir(dbDir)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err != nil {
+			_ = lock.discard()
+		}
+	}()
 
-// Put puts a key-value pair into the db.
-func (t *LSMTree) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return ErrKeyRequired
-	} else if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
-	} else if len(value) == 0 {
-		return ErrValueRequired
-	} else if uint64(len(value)) > MaxValueSize {
-		return ErrValueTooLarge
+	t := newLSMTree(dbDir, options)
+	t.lock = lock
+	if t.syncInterval <= 0 {
+		return nil, fmt.Errorf("invalid wal sync interval %v, it must be positive", t.syncInterval)
 	}
 
-	if err := appendToWAL(t.wal, key, value); err != nil {
-		return fmt.Errorf("failed to write wal %s: %w", t.wal.Name(), err)
+	version, err := loadVersion(dbDir)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := t.mt.put(key, value); err != nil {
-		return fmt.Errorf("failed to write memtable: %w", err)
+	// the WAL segments are created before the version which counts them is
+	// written, so the next number is after the newest segment.
+	numbers, err := listWALSegments(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list wal segments: %w", err)
+	}
+	nextFileNumber := version.nextFileNumber
+	if len(numbers) > 0 && numbers[len(numbers)-1] >= nextFileNumber {
+		nextFileNumber = numbers[len(numbers)-1] + 1
 	}
 
-	if t.mt.bytes() > t.memTableSizeThreshold {
-		if err := t.flushMemTable(); err != nil {
-			return fmt.Errorf("failed to flush memtable: %w", err)
+	legacyPaths, err := legacyWALPaths(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find legacy wal: %w", err)
+	}
+
+	mt, wal, walNumber, err := recoverWAL(dbDir, version.logNumber, nextFileNumber, version.lastSequence,
+		legacyPaths, t.walRecoveryMode, &t.recoveryStats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recover memtable from wal: %w", err)
+	}
+	if walNumber >= nextFileNumber {
+		nextFileNumber = walNumber + 1
+	}
+
+	walSize, err := wal.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = wal.Close()
+		return nil, fmt.Errorf("failed to seek to the end of %s: %w", wal.Name(), err)
+	}
+
+	// the new MANIFEST starts with the snapshot of the version, so the edits
+	// of the previous instances are not replayed again.
+	manifestNumber := nextFileNumber
+	nextFileNumber++
+	version.nextFileNumber, version.lastSequence = nextFileNumber, mt.lastSeq
+	manifest, err := createManifest(dbDir, manifestNumber, version)
+	if err != nil {
+		_ = wal.Close()
+		return nil, fmt.Errorf("failed to create manifest: %w", err)
+	}
+	manifestInfo, err := manifest.Stat()
+	if err != nil {
+		_ = wal.Close()
+		_ = manifest.Close()
+		return nil, fmt.Errorf("failed to stat %s: %w", manifest.Name(), err)
+	}
+
+	// the previous instance did not finish removing the obsolete files.
+	err = removeObsoleteWALSegments(dbDir, t.walArchiveDir, version.logNumber)
+	if err == nil {
+		err = removeObsoleteFiles(dbDir, version, manifestNumber)
+	}
+	if err != nil {
+		_ = wal.Close()
+		_ = manifest.Close()
+		return nil, fmt.Errorf("failed to remove obsolete files: %w", err)
+	}
+
+	version.ref()
+	t.wal, t.walNumber, t.walSize, t.mt = wal, walNumber, walSize, mt
+	t.version, t.nextFileNumber, t.manifest = version, nextFileNumber, manifest
+	t.manifestSnapshotSize = manifestInfo.Size()
+	t.lastSequence, mt.snapshots = mt.lastSeq, &t.snapshots
+
+	t.bgWg.Add(1)
+	go t.runCompactions()
+	t.triggerCompaction()
+
+	if t.syncMode == SyncPeriodic {
+		t.syncWg.Add(1)
+		go t.runWALSync()
+	}
+
+	// the legacy WAL files are never appended, so their changes are flushed
+	// before they are removed. If the flush fails, they are replayed again.
+	// The records without sequence numbers are numbered by the recovery, so
+	// they are flushed too, otherwise the next recovery numbers them again
+	// after the newer writes.
+	if len(legacyPaths) > 0 || t.recoveryStats.unsequenced > 0 {
+		if err := t.flush(); err != nil {
+			_ = t.Close()
+			return nil, fmt.Errorf("failed to flush memtable: %w", err)
+		}
+		if err := removeLegacyWAL(legacyPaths); err != nil {
+			_ = t.Close()
+			return nil, err
 		}
 	}
 
-	if t.ssTableNum >= t.ssTableNumberThreshold {
-		oldestIndex := t.maxSsTableIndex - t.ssTableNum + 1
-		if err := mergeSsTables(t.dbDir, oldestIndex, oldestIndex+1, t.sparseKeyDistance); err != nil {
-			return fmt.Errorf("failed to merge sstables: %w", err)
+	// the previous instance did not finish flushing before being stopped.
+	if t.mt.bytes() > t.memTableSizeThreshold {
+		t.writeMu.Lock()
+		err := t.freezeMemTable()
+		t.writeMu.Unlock()
+		if err != nil {
+			_ = t.Close()
+			return nil, fmt.Errorf("failed to freeze memtable: %w", err)
 		}
+	}
+
+	return t, nil
+}
+
+// OpenReadOnly opens the database for reading only. The WAL is loaded into
+// the MemTable, but it is not truncated or appended, and the tables are never
+// flushed or merged, so nothing in the directory is changed. The directory is
+// not locked, so several processes may read it concurrently, but the instance
+// opened by Open may remove the files which are being read. The writes return
+// ErrReadOnly.
+func OpenReadOnly(dbDir string, options ...func(*LSMTree)) (*LSMTree, error) {
+	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("directory %s does not exist", dbDir)
+	}
+
+	t := newLSMTree(dbDir, options)
+	t.readOnly = true
+
+	version, err := loadVersion(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	mt, err := readWAL(dbDir, version.logNumber, version.lastSequence, t.walRecoveryMode, &t.recoveryStats)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memtable from wal: %w", err)
+	}
+
+	version.ref()
+	t.mt, t.version, t.nextFileNumber = mt, version, version.nextFileNumber
+	t.lastSequence, mt.snapshots = mt.lastSeq, &t.snapshots
+	return t, nil
+}
+
+// Close closes all allocated resources and releases the directory lock.
+// The repeated Close returns ErrClosed.
+func (t *LSMTree) Close() (err error) {
+	if !atomic.CompareAndSwapInt32(&t.closed, 0, 1) {
+		return ErrClosed
+	}
+	defer t.tableCache.close()
+	if t.readOnly {
+		return nil
+	}
 
-		if err := updateSsTableMeta(t.dbDir, t.ssTableNum-1, t.maxSsTableIndex); err != nil {
-			return fmt.Errorf("failed to update sstable meta: %w", err)
+	defer func() {
+		if unlockErr := t.lock.unlock(); err == nil {
+			err = unlockErr
 		}
-		t.ssTableNum--
+	}()
+
+	// the WAL sync goroutine takes writeMu, so it is stopped first.
+	close(t.closing)
+	t.syncWg.Wait()
+
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
+
+	t.bgWg.Wait()
+
+	t.manifestMu.Lock()
+	defer t.manifestMu.Unlock()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := t.syncWAL(); err != nil {
+		return err
+	}
+
+	if err := t.wal.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", t.wal.Name(), err)
+	}
+
+	if err := t.manifest.Close(); err != nil {
+		return fmt.Errorf("failed to close file %s: %w", t.manifest.Name(), err)
+	}
+
+	if t.bgErr != nil {
+		return fmt.Errorf("background error: %w", t.bgErr)
 	}
 
 	return nil
 }
 
+// Put puts a key-value pair into the db.
+func (t *LSMTree) Put(key []byte, value []byte) error {
+	batch := NewWriteBatch()
+	batch.Put(key, value)
+	return t.Write(batch)
+}
+
+// PutWithTTL puts a key-value pair which expires after the given ttl into the db.
+// The expired value is treated as deleted by Get and iterators and it is removed
+// by the compaction, the non-positive ttl makes the value expire immediately.
+func (t *LSMTree) PutWithTTL(key, value []byte, ttl time.Duration) error {
+	batch := NewWriteBatch()
+	batch.PutWithTTL(key, value, ttl)
+	return t.Write(batch)
+}
+
+// Write applies all mutations of the batch atomically: the batch is written
+// to the WAL as a single record and then applied to the MemTable.
+func (t *LSMTree) Write(batch *WriteBatch) error {
+	return t.WriteWithOptions(batch, WriteOptions{})
+}
+
 // Get returns the value according to the key.
 func (t *LSMTree) Get(key []byte) ([]byte, bool, error) {
-	value, exists := t.mt.get(key)
-	if exists {
-		return value, value != nil, nil
+	// the writes are applied together with the last sequence number, so
+	// the lookup sees all of them.
+	return t.get(key, maxSequence)
+}
+
+// get returns the value of the key as of the write with the given sequence
+// number. The caller must not hold mu.
+func (t *LSMTree) get(key []byte, seq uint64) ([]byte, bool, error) {
+	e, exists, rangeDels, err := t.find(key, seq)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists && (e.expired(time.Now().UnixNano()) || rangeDels.covers(key, e.seq)) {
+		return nil, false, nil
+	}
+
+	return e.value, exists && e.value != nil, nil
+}
+
+// find returns the newest version of the key, including the deletion, whose
+// sequence number is not greater than the given one, and the range tombstones
+// visible as of the given sequence number, which are not applied to it.
+//
+// The MemTables are searched under mu, then the current version is referenced
+// and the SSTables are searched without mu, so the lookup neither blocks the
+// writers nor misses the tables deleted meanwhile. The caller must not hold mu.
+func (t *LSMTree) find(key []byte, seq uint64) (entry, bool, rangeDelView, error) {
+	t.mu.RLock()
+	rangeDels := t.rangeTombstones(seq)
+	if e, exists := t.mt.find(key, seq); exists {
+		t.mu.RUnlock()
+		return e, true, rangeDels, nil
+	}
+
+	if t.imm != nil {
+		if e, exists := t.imm.find(key, seq); exists {
+			t.mu.RUnlock()
+			return e, true, rangeDels, nil
+		}
 	}
 
-	value, exists, err := searchInSsTable(t.dbDir, t.maxSsTableIndex, key)
+	v := t.version
+	v.ref()
+	t.mu.RUnlock()
+
+	e, exists, err := v.find(t.tableCache, key, seq)
+	// the lookup does not fail if the obsolete tables are not deleted,
+	// they are deleted by the next Open.
+	_ = t.releaseVersion(v)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in sstables: %w", err)
+		return entry{}, false, rangeDelView{}, fmt.Errorf("failed to search in sstables: %w", err)
 	}
 
-	return value, exists, nil
+	return e, exists, rangeDels, nil
 }
 
 // Delete deletes the value by key from the db.
 func (t *LSMTree) Delete(key []byte) error {
-	if err := appendToWAL(t.wal, key, nil); err != nil {
-		return fmt.Errorf("failed to write wal: %w", err)
+	batch := NewWriteBatch()
+	batch.Delete(key)
+	return t.Write(batch)
+}
+
+// DeleteRange deletes all keys in range [start, end) from the db. The deletion
+// is written as a single range tombstone, so its cost does not depend on the
+// number of the deleted keys. The covered keys are dropped by the compaction.
+func (t *LSMTree) DeleteRange(start, end []byte) error {
+	batch := NewWriteBatch()
+	batch.DeleteRange(start, end)
+	return t.Write(batch)
+}
+
+// newFileNumber allocates the id for the new SSTable.
+func (t *LSMTree) newFileNumber() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	id := t.nextFileNumber
+	t.nextFileNumber++
+	return id
+}
+
+// ssTableOptions returns the options of the created SSTables.
+func (t *LSMTree) ssTableOptions() ssTableOptions {
+	return ssTableOptions{
+		sparseKeyDistance: t.sparseKeyDistance,
+		blockSize:         t.blockSize,
+		bloomBitsPerKey:   t.bloomBitsPerKey,
 	}
+}
+
+// flush flushes current MemTable onto the disk and waits until it is durable.
+func (t *LSMTree) flush() error {
+	t.writeMu.Lock()
+	defer t.writeMu.Unlock()
 
-	if err := t.mt.delete(key); err != nil {
-		return fmt.Errorf("failed to delete memtable: %w", err)
+	if !t.mt.empty() {
+		if err := t.freezeMemTable(); err != nil {
+			return fmt.Errorf("failed to freeze memtable: %w", err)
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.imm != nil && t.bgErr == nil {
+		t.flushed.Wait()
+	}
+
+	return t.bgErr
+}
+
+// freezeMemTable turns current MemTable into the immutable one, which is flushed
+// in the background, and replaces it with the new MemTable and WAL segment. If the previous
+// immutable MemTable is still being flushed, it waits for the flush to finish.
+// The caller must hold writeMu.
+func (t *LSMTree) freezeMemTable() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for t.imm != nil && t.bgErr == nil {
+		t.flushed.Wait()
+	}
+	if t.bgErr != nil {
+		return fmt.Errorf("background error: %w", t.bgErr)
+	}
+
+	// the WAL segments of the immutable MemTable must be durable until the flush ends.
+	if err := t.switchWAL(); err != nil {
+		return err
 	}
 
+	t.imm = t.mt
+	t.mt = newMemTable()
+	t.mt.snapshots = &t.snapshots
+
+	t.bgWg.Add(1)
+	go t.flushImmutableMemTable(t.walNumber)
+
 	return nil
 }
 
-// flushMemTable flushes current MemTable onto the disk and clear it.
-func (t *LSMTree) flushMemTable() error {
-	newSsTableNum := t.ssTableNum + 1
-	newSsTableIndex := t.maxSsTableIndex + 1
+// switchWAL syncs the current WAL segment and replaces it with the new one.
+// The caller must hold writeMu and mu.
+func (t *LSMTree) switchWAL() error {
+	if err := t.syncWAL(); err != nil {
+		return err
+	}
 
-	if err := createSsTable(t.mt, t.dbDir, newSsTableIndex, t.sparseKeyDistance); err != nil {
-		return fmt.Errorf("faied to create sstable %d: %w", newSsTableIndex, err)
+	number := t.nextFileNumber
+	t.nextFileNumber++
+	wal, err := rotateWAL(t.dbDir, t.wal, number)
+	if err != nil {
+		return fmt.Errorf("failed to rotate the WAL file: %w", err)
 	}
 
-	if err := updateSsTableMeta(t.dbDir, newSsTableNum, newSsTableIndex); err != nil {
-		return fmt.Errorf("failed to update max sstable index %d: %w", newSsTableIndex, err)
+	t.wal, t.walNumber, t.walSize = wal, number, 0
+	return nil
+}
+
+// flushImmutableMemTable flushes the immutable MemTable onto the disk. The WAL
+// segments older than logNumber contain only its changes, they are obsolete
+// since the version with the SSTable is written. It runs in the background.
+func (t *LSMTree) flushImmutableMemTable(logNumber int) {
+	defer t.bgWg.Done()
+
+	t.mu.RLock()
+	imm := t.imm
+	t.mu.RUnlock()
+
+	// the range tombstones are moved to the version. The table is not
+	// created if the MemTable contains only them.
+	edit := &versionEdit{logNumber: logNumber, rangeDels: imm.rangeDels}
+	id := 0
+	if imm.data.Size() > 0 {
+		id = t.newFileNumber()
+		m, err := createSsTable(imm, t.dbDir, id, t.ssTableOptions())
+		if err != nil {
+			t.mu.Lock()
+			t.bgErr = fmt.Errorf("faied to create sstable %d: %w", id, err)
+			t.flushed.Broadcast()
+			t.mu.Unlock()
+			return
+		}
+		edit.addTable(0, m)
 	}
+	err := t.installVersion(edit)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer t.flushed.Broadcast()
 
-	newWal, err := clearWAL(t.dbDir, t.wal)
 	if err != nil {
-		return fmt.Errorf("failed to clear the WAL file: %w", err)
+		t.bgErr = fmt.Errorf("failed to install sstable %d: %w", id, err)
+		return
 	}
 
-	t.wal = newWal
-	t.mt.clear()
-	t.ssTableNum = newSsTableNum
-	t.maxSsTableIndex = newSsTableIndex
+	if err := removeObsoleteWALSegments(t.dbDir, t.walArchiveDir, logNumber); err != nil {
+		t.bgErr = fmt.Errorf("failed to remove obsolete WAL segments: %w", err)
+		return
+	}
 
-	return nil
+	t.imm = nil
+	t.triggerCompaction()
 }
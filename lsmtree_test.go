@@ -5,12 +5,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"strconv"
+	"sync"
 	"testing"
 )
 
 // @Author KHighness
-// @Update 2023-10-02
+// @Update 2026-10-16
 
 func TestApi(t *testing.T) {
 	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
@@ -84,4 +86,291 @@ func TestApi(t *testing.T) {
 	if err := tree.Close(); err != nil {
 		panic(fmt.Errorf("failed to close: %w", err))
 	}
+	if err := tree.Close(); err != ErrClosed {
+		t.Fatalf("the repeated Close expected err=%v, actual err=%v", ErrClosed, err)
+	}
+}
+
+func TestConcurrency(t *testing.T) {
+	tree, cleanup := prepareTree(t, SparseKeyDistance(8), MemTableSizeThreshold(1000))
+	defer cleanup()
+
+	const writers = 4
+	const readers = 4
+	const keys = 300
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers+readers)
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := []byte(fmt.Sprintf("%d-%04d", w, i))
+				if err := tree.Put(key, key); err != nil {
+					errs <- fmt.Errorf("Put error: %w", err)
+					return
+				}
+				if i%10 == 0 {
+					if err := tree.Delete(key); err != nil {
+						errs <- fmt.Errorf("Delete error: %w", err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+
+	for r := 0; r < readers; r++ {
+		wg.Add(1)
+		go func(r int) {
+			defer wg.Done()
+			for i := 0; i < keys; i++ {
+				key := []byte(fmt.Sprintf("%d-%04d", r%writers, i))
+				value, ok, err := tree.Get(key)
+				if err != nil {
+					errs <- fmt.Errorf("Get error: %w", err)
+					return
+				}
+				if ok && !bytes.Equal(key, value) {
+					errs <- fmt.Errorf("Get key=%s, unexpected value=%s", key, value)
+					return
+				}
+
+				if i%50 == 0 {
+					it, err := tree.NewIterator(nil, nil)
+					if err != nil {
+						errs <- fmt.Errorf("NewIterator error: %w", err)
+						return
+					}
+					for err == nil && it.Valid() {
+						if !bytes.Equal(it.Key(), it.Value()) {
+							err = fmt.Errorf("Iterator key=%s, unexpected value=%s", it.Key(), it.Value())
+						} else if err = it.Next(); err != nil {
+							err = fmt.Errorf("Next error: %w", err)
+						}
+					}
+					if err != nil {
+						errs <- err
+						_ = it.Close()
+						return
+					}
+					if err := it.Close(); err != nil {
+						errs <- fmt.Errorf("Close error: %w", err)
+						return
+					}
+				}
+			}
+		}(r)
+	}
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	for w := 0; w < writers; w++ {
+		for i := 0; i < keys; i++ {
+			key := []byte(fmt.Sprintf("%d-%04d", w, i))
+			value, ok, err := tree.Get(key)
+			if err != nil {
+				t.Fatalf("Get error: %s", err)
+			}
+			if ok != (i%10 != 0) || (ok && !bytes.Equal(key, value)) {
+				t.Fatalf("Get key=%s, unexpected ok=%v value=%s", key, ok, value)
+			}
+		}
+	}
+}
+
+func TestBackgroundFlush(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir, MemTableSizeThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(strconv.Itoa(i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+		if value, ok, err := tree.Get(key); err != nil || !ok || !bytes.Equal(key, value) {
+			t.Fatalf("Get key=%s, unexpected ok=%v value=%s err=%v", key, ok, value, err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the stop before the flush: the WAL segments contain more
+	// changes than the threshold allows.
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 100; i < 200; i++ {
+		key := []byte(strconv.Itoa(i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("Put error: %s", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir, MemTableSizeThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 200; i++ {
+		key := []byte(strconv.Itoa(i))
+		if value, ok, err := tree.Get(key); err != nil || !ok || !bytes.Equal(key, value) {
+			t.Fatalf("Get key=%s, unexpected ok=%v value=%s err=%v", key, ok, value, err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if segments, err := listWALSegments(dbDir); err != nil || len(segments) != 1 {
+		t.Fatalf("the obsolete WAL segments must be removed after the flush, segments=%v err=%v", segments, err)
+	}
+	if tree.imm != nil {
+		t.Fatalf("the immutable MemTable must be flushed on close")
+	}
+}
+
+func TestOpenReadOnly(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir, MemTableSizeThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 50)
+	walPath := tree.wal.Name()
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the torn record must be ignored, but not truncated.
+	wal, err := os.OpenFile(walPath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wal.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	listFiles := func() map[string]int64 {
+		infos, err := ioutil.ReadDir(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files := make(map[string]int64, len(infos))
+		for _, info := range infos {
+			files[info.Name()] = info.Size()
+		}
+		return files
+	}
+	before := listFiles()
+
+	trees := make([]*LSMTree, 2)
+	for i := range trees {
+		if trees[i], err = OpenReadOnly(dbDir); err != nil {
+			t.Fatalf("OpenReadOnly error: %s", err)
+		}
+	}
+	for _, tree := range trees {
+		checkRange(t, tree, 0, 50)
+		if err := tree.Put([]byte("key"), []byte("value")); err != ErrReadOnly {
+			t.Fatalf("Put expected err=%v, actual err=%v", ErrReadOnly, err)
+		}
+		if err := tree.CompactRange(nil, nil); err != ErrReadOnly {
+			t.Fatalf("CompactRange expected err=%v, actual err=%v", ErrReadOnly, err)
+		}
+		if stats := tree.RecoveryStats(); stats.TruncatedBytes != 3 {
+			t.Fatalf("the torn record must be dropped, stats=%+v", stats)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	after := listFiles()
+	if fmt.Sprint(before) != fmt.Sprint(after) {
+		t.Fatalf("OpenReadOnly must not change files, before=%v after=%v", before, after)
+	}
+}
+
+func TestLSMTree_GetReferencedVersion(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	// the overwritten keys make the tables overlap, so they are merged.
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 100)
+	putRange(t, tree, 0, 100)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the lookup references the version, so the compaction does not delete
+	// its tables until the lookup finishes.
+	tree.mu.RLock()
+	v := tree.version
+	v.ref()
+	tree.mu.RUnlock()
+
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if e, ok, err := v.find(tree.tableCache, key, maxSequence); err != nil || !ok || !bytes.Equal(e.value, key) {
+			t.Fatalf("find key=%s in the referenced version, unexpected value=%s ok=%v err=%v", key, e.value, ok, err)
+		}
+	}
+
+	if err := tree.releaseVersion(v); err != nil {
+		t.Fatal(err)
+	}
+	live := make(map[int]bool)
+	tree.mu.RLock()
+	for level := 0; level < levelNum; level++ {
+		for _, m := range tree.version.levels[level] {
+			live[m.id] = true
+		}
+	}
+	tree.mu.RUnlock()
+	deleted := 0
+	for level := 0; level < levelNum; level++ {
+		for _, m := range v.levels[level] {
+			if live[m.id] {
+				continue
+			}
+			tablePath := path.Join(tree.dbDir, strconv.Itoa(m.id)+"-"+ssTableFileName)
+			if _, err := os.Stat(tablePath); !os.IsNotExist(err) {
+				t.Fatalf("the obsolete table %d must be deleted after the release, err=%v", m.id, err)
+			}
+			deleted++
+		}
+	}
+	if deleted == 0 {
+		t.Fatalf("the compaction expected to replace the tables of the referenced version")
+	}
+	checkRange(t, tree, 0, 100)
 }
@@ -0,0 +1,421 @@
+package lsmtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// currentFileName is the name of the file which contains the name of the current MANIFEST.
+	currentFileName = "CURRENT"
+	// manifestFileName is MANIFEST file name, it is suffixed by the file number.
+	// The MANIFEST is the append-only log of version edits.
+	manifestFileName = "MANIFEST"
+	// tempFileSuffix is the suffix of the file being written, which is renamed
+	// when it is complete.
+	tempFileSuffix = ".tmp"
+)
+
+// The tags of the encoded version edit fields.
+const (
+	editTagLogNumber = iota + 1
+	editTagNextFileNumber
+	editTagDeletedTable
+	editTagAddedTable
+	editTagLastSequence
+	editTagRangeTombstone
+	editTagDeletedRangeTombstone
+	editTagTableGarbage
+	editTagTableExpiration
+)
+
+// encodeEdit encodes the version edit.
+// The function must be compatible with decodeEdit.
+//
+//	Encode format:
+//	[tag][field]...
+//	Field format:
+//	log number, next file number, last sequence: [number]
+//	deleted table: [level][id]
+//	added table: [level][id][size][encoded smallest and largest key]
+//	table garbage, which follows the added table: [id][garbage sequence number]
+//	table expiration, which follows the added table: [id][min expiration time]
+//	range tombstone: [sequence number][encoded start and end key]
+//	deleted range tombstone: [sequence number]
+func encodeEdit(e *versionEdit) ([]byte, error) {
+	var buf bytes.Buffer
+	if e.logNumber > 0 {
+		buf.Write(encodeIntPair(editTagLogNumber, e.logNumber))
+	}
+	if e.nextFileNumber > 0 {
+		buf.Write(encodeIntPair(editTagNextFileNumber, e.nextFileNumber))
+	}
+	if e.lastSequence > 0 {
+		buf.Write(encodeIntPair(editTagLastSequence, int(e.lastSequence)))
+	}
+	for level := 0; level < levelNum; level++ {
+		for _, id := range e.deleted[level] {
+			buf.Write(encodeIntPair(editTagDeletedTable, level))
+			buf.Write(encodeInt(id))
+		}
+		for _, m := range e.added[level] {
+			buf.Write(encodeIntPair(editTagAddedTable, level))
+			buf.Write(encodeIntPair(m.id, m.size))
+			if _, err := encode(m.smallest, m.largest, &buf); err != nil {
+				return nil, fmt.Errorf("failed to encode table %d: %w", m.id, err)
+			}
+			if m.garbageSeq > 0 {
+				buf.Write(encodeIntPair(editTagTableGarbage, m.id))
+				buf.Write(encodeInt(int(m.garbageSeq)))
+			}
+			if m.minExpiresAt != 0 {
+				buf.Write(encodeIntPair(editTagTableExpiration, m.id))
+				buf.Write(encodeInt(int(m.minExpiresAt)))
+			}
+		}
+	}
+	for _, r := range e.rangeDels {
+		buf.Write(encodeIntPair(editTagRangeTombstone, int(r.seq)))
+		if _, err := encode(r.start, r.end, &buf); err != nil {
+			return nil, fmt.Errorf("failed to encode range tombstone %d: %w", r.seq, err)
+		}
+	}
+	for _, seq := range e.deletedRangeDels {
+		buf.Write(encodeIntPair(editTagDeletedRangeTombstone, int(seq)))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decodeEdit decodes the version edit encoded by encodeEdit.
+func decodeEdit(data []byte) (*versionEdit, error) {
+	r := bytes.NewReader(data)
+	e := &versionEdit{}
+
+	var buf [16]byte
+	for r.Len() > 0 {
+		if _, err := io.ReadFull(r, buf[:]); err != nil {
+			return nil, err
+		}
+
+		tag, value := decodeIntPair(buf[:])
+		switch tag {
+		case editTagLogNumber:
+			e.logNumber = value
+		case editTagNextFileNumber:
+			e.nextFileNumber = value
+		case editTagLastSequence:
+			if value < 0 || value > maxSequence {
+				return nil, fmt.Errorf("unexpected sequence number %d", value)
+			}
+			e.lastSequence = uint64(value)
+		case editTagDeletedRangeTombstone:
+			if value <= 0 || value > maxSequence {
+				return nil, fmt.Errorf("unexpected sequence number %d", value)
+			}
+			e.deleteRangeTombstone(uint64(value))
+		case editTagRangeTombstone:
+			if value <= 0 || value > maxSequence {
+				return nil, fmt.Errorf("unexpected sequence number %d", value)
+			}
+			start, end, err := decode(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode range tombstone %d: %w", value, err)
+			}
+			e.rangeDels = append(e.rangeDels, rangeTombstone{start: start, end: end, seq: uint64(value)})
+		case editTagTableGarbage:
+			m := e.addedTable(value)
+			if m == nil {
+				return nil, fmt.Errorf("unexpected table %d", value)
+			}
+			if _, err := io.ReadFull(r, buf[:8]); err != nil {
+				return nil, err
+			}
+			seq := decodeInt(buf[:8])
+			if seq <= 0 || seq > maxSequence {
+				return nil, fmt.Errorf("unexpected sequence number %d", seq)
+			}
+			m.garbageSeq = uint64(seq)
+		case editTagTableExpiration:
+			m := e.addedTable(value)
+			if m == nil {
+				return nil, fmt.Errorf("unexpected table %d", value)
+			}
+			if _, err := io.ReadFull(r, buf[:8]); err != nil {
+				return nil, err
+			}
+			m.minExpiresAt = int64(decodeInt(buf[:8]))
+		case editTagDeletedTable, editTagAddedTable:
+			if value < 0 || value >= levelNum {
+				return nil, fmt.Errorf("unexpected level %d", value)
+			}
+			if tag == editTagDeletedTable {
+				if _, err := io.ReadFull(r, buf[:8]); err != nil {
+					return nil, err
+				}
+				e.deleteTable(value, decodeInt(buf[:8]))
+				continue
+			}
+
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, err
+			}
+			id, size := decodeIntPair(buf[:])
+			smallest, largest, err := decode(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode table %d: %w", id, err)
+			}
+			e.addTable(value, &tableMeta{id: id, size: size, smallest: smallest, largest: largest})
+		default:
+			return nil, fmt.Errorf("unexpected tag %d", tag)
+		}
+	}
+
+	return e, nil
+}
+
+// snapshotEdit returns the edit which creates the version from the empty one.
+func snapshotEdit(v *version) *versionEdit {
+	e := &versionEdit{
+		logNumber:      v.logNumber,
+		nextFileNumber: v.nextFileNumber,
+		lastSequence:   v.lastSequence,
+		rangeDels:      v.rangeDels,
+	}
+	for level, tables := range v.levels {
+		for _, m := range tables {
+			e.addTable(level, m)
+		}
+	}
+	return e
+}
+
+// manifestPath returns the path of the MANIFEST with the given number.
+func manifestPath(dbDir string, number int) string {
+	return path.Join(dbDir, manifestFileName+"-"+strconv.Itoa(number))
+}
+
+// appendEdit appends the version edit to the MANIFEST and syncs it, the edit
+// is committed once it is durable.
+func appendEdit(manifest *os.File, e *versionEdit) error {
+	data, err := encodeEdit(e)
+	if err != nil {
+		return fmt.Errorf("failed to encode version edit: %w", err)
+	}
+
+	var record bytes.Buffer
+	encodeRecord(data, &record)
+	if _, err := manifest.Write(record.Bytes()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifest.Name(), err)
+	}
+	if err := manifest.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", manifest.Name(), err)
+	}
+
+	return nil
+}
+
+// createManifest creates the new MANIFEST with the given number, which starts
+// with the snapshot of the version, and makes it current.
+func createManifest(dbDir string, number int, v *version) (*os.File, error) {
+	filePath := manifestPath(dbDir, number)
+	manifest, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file %s: %w", filePath, err)
+	}
+
+	if err := appendEdit(manifest, snapshotEdit(v)); err != nil {
+		_ = manifest.Close()
+		return nil, err
+	}
+
+	if err := setCurrentManifest(dbDir, number); err != nil {
+		_ = manifest.Close()
+		return nil, err
+	}
+
+	return manifest, nil
+}
+
+// setCurrentManifest atomically replaces CURRENT to point to the MANIFEST with the
+// given number: the temporary file is written and renamed to CURRENT.
+func setCurrentManifest(dbDir string, number int) error {
+	currentPath := path.Join(dbDir, currentFileName)
+	tempPath := currentPath + tempFileSuffix
+
+	temp, err := os.OpenFile(tempPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file %s: %w", tempPath, err)
+	}
+	_, err = temp.WriteString(path.Base(manifestPath(dbDir, number)) + "\n")
+	if err == nil {
+		err = temp.Sync()
+	}
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write file %s: %w", tempPath, err)
+	}
+
+	if err := os.Rename(tempPath, currentPath); err != nil {
+		return fmt.Errorf("failed to rename file %s: %w", tempPath, err)
+	}
+
+	return syncDir(dbDir)
+}
+
+// syncDir syncs the directory, so the created and renamed files are durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// readManifest replays the edits of the current MANIFEST into the version and
+// returns it with the MANIFEST number. Returns nil version if there is no
+// CURRENT file. The torn edit at the end of the MANIFEST is left by the crash
+// during the write, it was not committed, so it is ignored.
+func readManifest(dbDir string) (*version, int, error) {
+	currentPath := path.Join(dbDir, currentFileName)
+	current, err := ioutil.ReadFile(currentPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, 0, nil
+		}
+		return nil, 0, fmt.Errorf("failed to read file %s: %w", currentPath, err)
+	}
+
+	name := strings.TrimSuffix(string(current), "\n")
+	number, err := strconv.Atoi(strings.TrimPrefix(name, manifestFileName+"-"))
+	if err != nil || !strings.HasPrefix(name, manifestFileName+"-") {
+		return nil, 0, &CorruptionError{Path: currentPath, Reason: fmt.Sprintf("unexpected MANIFEST name %q", name)}
+	}
+
+	filePath := manifestPath(dbDir, number)
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	v := &version{}
+	for offset := 0; offset < len(data); {
+		editData, size, err := decodeRecord(data[offset:])
+		if err == errIncompleteRecord {
+			break
+		}
+
+		var corruption *CorruptionError
+		if errors.As(err, &corruption) {
+			corruption.Path, corruption.Offset = filePath, int64(offset)
+			return nil, 0, corruption
+		} else if err != nil {
+			return nil, 0, err
+		}
+
+		e, err := decodeEdit(editData)
+		if err != nil {
+			return nil, 0, &CorruptionError{Path: filePath, Offset: int64(offset), Reason: fmt.Sprintf("failed to decode version edit: %s", err)}
+		}
+
+		nextFileNumber := v.nextFileNumber
+		if e.nextFileNumber > nextFileNumber {
+			nextFileNumber = e.nextFileNumber
+		}
+		v = v.apply(e, nextFileNumber)
+		offset += size
+	}
+
+	return v, number, nil
+}
+
+// loadVersion reads the current version from the MANIFEST. If there is no
+// MANIFEST, the database is empty or it is created by the older version,
+// which kept the tables in the meta file.
+func loadVersion(dbDir string) (*version, error) {
+	v, _, err := readManifest(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	if v == nil {
+		if v, err = readVersion(dbDir); err != nil {
+			return nil, fmt.Errorf("failed to read sstable meta: %w", err)
+		}
+	}
+
+	return v, nil
+}
+
+// removeObsoleteFiles removes the files which are not referenced by the version:
+// the tables left by the interrupted flushes and compactions, the old MANIFESTs,
+// the temporary files and the legacy meta file. The WAL segments are removed
+// separately by removeObsoleteWALSegments.
+func removeObsoleteFiles(dbDir string, v *version, manifestNumber int) error {
+	infos, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		return fmt.Errorf("failed to read directory %s: %w", dbDir, err)
+	}
+
+	live := make(map[int]bool, v.tableNum())
+	for _, tables := range v.levels {
+		for _, m := range tables {
+			live[m.id] = true
+		}
+	}
+
+	tableFileNames := map[string]bool{
+		ssTableFileName:           true,
+		legacyDataFileName:        true,
+		legacyIndexFileName:       true,
+		legacySparseIndexFileName: true,
+		legacyFilterFileName:      true,
+	}
+
+	for _, info := range infos {
+		name := info.Name()
+
+		obsolete := false
+		switch {
+		case name == ssTableMetaFileName || strings.HasSuffix(name, tempFileSuffix):
+			obsolete = true
+		case strings.HasPrefix(name, manifestFileName+"-"):
+			number, err := strconv.Atoi(strings.TrimPrefix(name, manifestFileName+"-"))
+			obsolete = err == nil && number != manifestNumber
+		default:
+			i := strings.Index(name, "-")
+			if i < 0 || !tableFileNames[name[i+1:]] {
+				continue
+			}
+			id, err := strconv.Atoi(name[:i])
+			obsolete = err == nil && !live[id]
+		}
+
+		if !obsolete {
+			continue
+		}
+		if err := os.Remove(path.Join(dbDir, name)); err != nil {
+			return fmt.Errorf("failed to remove file %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package lsmtree
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestVersionEdit_encode(t *testing.T) {
+	edit := &versionEdit{logNumber: 7, nextFileNumber: 8}
+	edit.addTable(0, &tableMeta{id: 3, size: 30, smallest: []byte("a"), largest: []byte("z")})
+	edit.addTable(0, &tableMeta{id: 1, size: 10, smallest: []byte("b"), largest: []byte("c")})
+	edit.addTable(2, &tableMeta{id: 5, size: 50, smallest: []byte("m"), largest: []byte("n"), garbageSeq: 6, minExpiresAt: 11})
+	edit.deleteTable(1, 2)
+	edit.rangeDels = rangeTombstones{{start: []byte("d"), end: []byte("f"), seq: 9}}
+	edit.deleteRangeTombstone(4)
+
+	data, err := encodeEdit(edit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeEdit(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(edit, decoded) {
+		t.Fatalf("decodeEdit expected %+v, actual %+v", edit, decoded)
+	}
+
+	if _, err := decodeEdit(data[:len(data)-1]); err == nil {
+		t.Fatalf("decodeEdit must fail on the truncated edit")
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	edit := &versionEdit{}
+	edit.addTable(0, &tableMeta{id: 3, size: 30, smallest: []byte("a"), largest: []byte("z")})
+	edit.addTable(0, &tableMeta{id: 1, size: 10, smallest: []byte("b"), largest: []byte("c")})
+	edit.addTable(2, &tableMeta{id: 5, size: 50, smallest: []byte("m"), largest: []byte("n")})
+	edit.addTable(2, &tableMeta{id: 4, size: 40, smallest: []byte("k"), largest: []byte("l")})
+	v := (&version{logNumber: 2}).apply(edit, 6)
+
+	manifest, err := createManifest(dbDir, 6, v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit = &versionEdit{nextFileNumber: 8}
+	edit.deleteTable(0, 1)
+	edit.addTable(1, &tableMeta{id: 7, size: 10, smallest: []byte("b"), largest: []byte("c")})
+	if err := appendEdit(manifest, edit); err != nil {
+		t.Fatal(err)
+	}
+	v = v.apply(edit, 8)
+
+	// simulate the crash during the write of the next edit.
+	if _, err := manifest.Write(encodeInt(100 | walChecksumFlag)); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	decoded, number, err := readManifest(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if number != 6 || !reflect.DeepEqual(v, decoded) {
+		t.Fatalf("readManifest expected number=6 version=%+v, actual number=%d version=%+v", v, number, decoded)
+	}
+	if decoded.levels[0][0].id != 3 || decoded.levels[2][0].id != 4 {
+		t.Fatalf("level 0 must keep the order, deeper levels must be sorted by keys")
+	}
+}
+
+func TestLSMTree_ObsoleteFiles(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir, MemTableSizeThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 50)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate the crash during the compaction: the output table is written,
+	// but the edit is not committed.
+	orphan := path.Join(dbDir, strconv.Itoa(1000)+"-"+ssTableFileName)
+	temp := path.Join(dbDir, currentFileName+tempFileSuffix)
+	for _, filePath := range []string{orphan, temp} {
+		if err := ioutil.WriteFile(filePath, []byte("orphan"), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err = Open(dbDir, MemTableSizeThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 50)
+
+	for _, filePath := range []string{orphan, temp} {
+		if _, err := os.Stat(filePath); !os.IsNotExist(err) {
+			t.Fatalf("the obsolete file %s must be removed, stat err=%v", filePath, err)
+		}
+	}
+
+	infos, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests := 0
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), manifestFileName+"-") {
+			manifests++
+		}
+	}
+	if manifests != 1 {
+		t.Fatalf("only the current MANIFEST must be kept, actual %d", manifests)
+	}
+}
+
+func TestLSMTree_RollManifest(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	options := []func(*LSMTree){MemTableSizeThreshold(100), MaxManifestSize(200)}
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree.mu.RLock()
+	first := tree.nextFileNumber - 1
+	tree.mu.RUnlock()
+
+	putRange(t, tree, 0, 100)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tree.manifestMu.Lock()
+	manifestName := path.Base(tree.manifest.Name())
+	tree.manifestMu.Unlock()
+	if manifestName == path.Base(manifestPath(dbDir, first)) {
+		t.Fatalf("the MANIFEST must be rolled at the size limit, actual %s", manifestName)
+	}
+	infos, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, info := range infos {
+		if strings.HasPrefix(info.Name(), manifestFileName+"-") && info.Name() != manifestName {
+			t.Fatalf("the rolled MANIFEST %s must be removed", info.Name())
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 100)
+}
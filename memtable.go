@@ -1,19 +1,33 @@
 package lsmtree
 
 import (
+	"bytes"
+
 	"github.com/Khighness/gokit/rbtree"
 )
 
 // @Author KHighness
-// @Update 2023-09-29
+// @Update 2026-10-16
 
 // memTable is memory cache of SSTable.
 // All changed that are flushed to the WAL, but not flushed to
 // the sorted files, are sorted in memory for faster lookups.
+// The value of the key is the list of its versions from the newest to the
+// oldest, see encodeVersions. The older versions are kept only while they
+// are visible to the live snapshots.
 type memTable struct {
 	data *rbtree.Tree
 	// b is the size of al the keys and values inserted into
 	b int
+	// lastSeq is the greatest sequence number applied to the table.
+	lastSeq uint64
+	// rangeDels are the range tombstones written to the table.
+	rangeDels rangeTombstones
+	// rangeDelIndex is the index of rangeDels used by the lookups.
+	rangeDelIndex rangeDelIndex
+	// snapshots are the live snapshots of the tree, nil if there are none.
+	// They are guarded by mu of the tree like the table itself.
+	snapshots *snapshotList
 }
 
 // newMemTable creates a new instance of the MemTable.
@@ -24,33 +38,93 @@ func newMemTable() *memTable {
 	}
 }
 
-// put puts the key and value into the table.
-func (mt *memTable) put(key, value []byte) error {
-	prev, exists := mt.data.Put(key, value)
-	if exists {
-		mt.b += -len(prev) + len(value)
-	} else {
-		mt.b += len(key) + len(value)
+// put puts the key and value with the sequence number into the table.
+func (mt *memTable) put(key, value []byte, seq uint64) error {
+	mt.add(key, entry{value: value, seq: seq})
+	return nil
+}
+
+// get returns the newest value of the key whose sequence number is not greater
+// than the given one.
+func (mt *memTable) get(key []byte, seq uint64) ([]byte, bool) {
+	e, exists := mt.find(key, seq)
+	return e.value, exists
+}
+
+// find returns the newest version of the key whose sequence number is not
+// greater than the given one.
+func (mt *memTable) find(key []byte, seq uint64) (entry, bool) {
+	data, exists := mt.data.Get(key)
+	if !exists {
+		return entry{}, false
 	}
 
+	for _, e := range decodeVersions(data) {
+		if e.seq <= seq {
+			e.key = key
+			return e, true
+		}
+	}
+	return entry{}, false
+}
+
+// delete marks the key as deleted in the table with the sequence number,
+// but does not remove it.
+func (mt *memTable) delete(key []byte, seq uint64) error {
+	mt.add(key, entry{seq: seq})
 	return nil
 }
 
-// get returns thr value according to the key.
-func (mt *memTable) get(key []byte) ([]byte, bool) {
-	return mt.data.Get(key)
+// deleteRange marks all keys in range [start, end) as deleted with the
+// sequence number. The keys are not changed, the tombstone shadows them.
+func (mt *memTable) deleteRange(start, end []byte, seq uint64) {
+	r := rangeTombstone{start: start, end: end, seq: seq}
+	mt.rangeDels = append(mt.rangeDels, r)
+	// the index is replaced instead of being changed, the lookups may still
+	// use the previous one without mu, see rangeDelView.
+	mt.rangeDelIndex = mt.rangeDelIndex.insert(r)
+	mt.b += len(start) + len(end)
 }
 
-// delete marks the key as deleted in the table, but does not remove it.
-func (mt *memTable) delete(key []byte) error {
-	value, exists := mt.data.Put(key, nil)
-	if !exists {
-		mt.b += len(key)
+// add adds the new version of the key. The older versions, which are not
+// visible to any live snapshot anymore, are dropped.
+func (mt *memTable) add(key []byte, version entry) {
+	var versions []entry
+	if data, exists := mt.data.Get(key); exists {
+		versions = decodeVersions(data)
 	} else {
-		mt.b -= len(value)
+		mt.b += len(key)
 	}
 
-	return nil
+	kept := append(make([]entry, 0, len(versions)+1), version)
+	mt.b += len(version.value)
+	next := version.seq
+	for _, e := range versions {
+		if mt.snapshots.visible(e.seq, next) {
+			kept = append(kept, e)
+		} else {
+			mt.b -= len(e.value)
+		}
+		next = e.seq
+	}
+
+	mt.data.Put(key, encodeVersions(kept))
+}
+
+// apply applies all mutations of the batch to the table, the mutations get
+// the sequence numbers starting from the sequence number of the batch.
+func (mt *memTable) apply(batch *WriteBatch) {
+	for i, e := range batch.entries {
+		seq := batch.seq + uint64(i)
+		if e.rangeDelete {
+			mt.deleteRange(e.key, e.value, seq)
+		} else {
+			mt.add(e.key, entry{value: e.value, seq: seq, expiresAt: e.expiresAt})
+		}
+		if seq > mt.lastSeq {
+			mt.lastSeq = seq
+		}
+	}
 }
 
 // bytes returns the size of all keys and values inserted into thd memTable in bytes.
@@ -58,29 +132,76 @@ func (mt *memTable) bytes() int {
 	return mt.b
 }
 
+// empty returns true if nothing is written to the table.
+func (mt *memTable) empty() bool {
+	return mt.data.Size() == 0 && len(mt.rangeDels) == 0
+}
+
 // clear clears all the data and resets the size.
 func (mt *memTable) clear() {
 	mt.data = rbtree.New()
+	mt.rangeDels = nil
+	mt.rangeDelIndex = nil
 	mt.b = 0
 }
 
-// iterator returns iterator for MemTable. It also iterates over
-// deleted keys, but the value for them is nil.
+// iterator returns iterator for MemTable. It iterates over all versions of
+// the keys, including deleted keys, but the value for them is nil.
 func (mt *memTable) iterator() *memTableIterator {
-	return &memTableIterator{mt.data.Iterator()}
+	return &memTableIterator{it: mt.data.Iterator()}
 }
 
 // memTableIterator is iterator of MemTable.
 type memTableIterator struct {
 	it *rbtree.Iterator
+	// versions are the remaining versions of the current key.
+	versions []entry
 }
 
 // hasNext returns true if there is next element.
 func (it *memTableIterator) hasNext() bool {
-	return it.it.HasNext()
+	return len(it.versions) > 0 || it.it.HasNext()
 }
 
-// next returns thr current key and value and advances thr iterator position.
-func (it *memTableIterator) next() ([]byte, []byte) {
-	return it.it.Next()
+// next returns thr current record and advances thr iterator position.
+// The versions of the same key are returned from the newest to the oldest.
+func (it *memTableIterator) next() entry {
+	if len(it.versions) == 0 {
+		key, data := it.it.Next()
+		it.versions = decodeVersions(data)
+		for i := range it.versions {
+			it.versions[i].key = key
+		}
+	}
+
+	e := it.versions[0]
+	it.versions = it.versions[1:]
+	return e
+}
+
+// encodeVersions encodes the versions of the key.
+// The function must be compatible with decodeVersions.
+//
+//	Encode format:
+//	[encoded value length][value encoded by encodeInternalValue]...
+func encodeVersions(versions []entry) []byte {
+	var buf bytes.Buffer
+	for _, e := range versions {
+		value := encodeInternalValue(e)
+		buf.Write(encodeInt(len(value)))
+		buf.Write(value)
+	}
+	return buf.Bytes()
+}
+
+// decodeVersions decodes the versions of the key encoded by encodeVersions.
+func decodeVersions(data []byte) []entry {
+	versions := make([]entry, 0, 1)
+	for len(data) > 0 {
+		size := decodeInt(data[:8])
+		e, _ := decodeInternalValue(data[8 : 8+size])
+		versions = append(versions, e)
+		data = data[8+size:]
+	}
+	return versions
 }
@@ -7,7 +7,7 @@ import (
 )
 
 // @Author KHighness
-// @Update 2023-10-01
+// @Update 2026-10-16
 
 func TestMemTable_put(t *testing.T) {
 	const keySize = 64
@@ -15,7 +15,7 @@ func TestMemTable_put(t *testing.T) {
 	const length = 100
 	mt := newMemTable()
 	for i := 0; i < length; i++ {
-		err := mt.put(randBytes(keySize), randBytes(valueSize))
+		err := mt.put(randBytes(keySize), randBytes(valueSize), uint64(i+1))
 		if err != nil {
 			t.Error(err)
 		}
@@ -36,13 +36,13 @@ func TestMemTable_get(t *testing.T) {
 	for i := 0; i < length; i++ {
 		key := randBytes(64)
 		keys = append(keys, key)
-		err := mt.put(key, randBytes(1024))
+		err := mt.put(key, randBytes(1024), uint64(i+1))
 		if err != nil {
 			t.Error(err)
 		}
 	}
 	for _, k := range keys {
-		_, ok := mt.get(k)
+		_, ok := mt.get(k, maxSequence)
 		if !ok {
 			t.Error("the key does not exist in memtable")
 		}
@@ -57,13 +57,13 @@ func TestMemTable_delete(t *testing.T) {
 	for i := 0; i < length; i++ {
 		key := randBytes(keySize)
 		keys = append(keys, key)
-		err := mt.put(key, randBytes(1024))
+		err := mt.put(key, randBytes(1024), uint64(i+1))
 		if err != nil {
 			t.Error(err)
 		}
 	}
-	for _, k := range keys {
-		err := mt.delete(k)
+	for i, k := range keys {
+		err := mt.delete(k, uint64(length+i+1))
 		if err != nil {
 			t.Error(err)
 		}
@@ -81,7 +81,7 @@ func TestMemTable_clear(t *testing.T) {
 	const length = 100
 	mt := newMemTable()
 	for i := 0; i < length; i++ {
-		err := mt.put(randBytes(64), randBytes(1024))
+		err := mt.put(randBytes(64), randBytes(1024), uint64(i+1))
 		if err != nil {
 			t.Error(err)
 		}
@@ -2,191 +2,290 @@ package lsmtree
 
 import (
 	"bytes"
+	"container/heap"
 	"fmt"
-	"io"
-	"os"
-	"path"
-	"strconv"
 )
 
 // @Author KHighness
-// @Update 2023-10-01
-
-// mergeSsTables merges SSTables with index a and b
-// and creates new merge table with index b.
-// The index a must be less than be and to be older.
-func mergeSsTables(dbDir string, a, b int, sparseKeyDistance int) error {
-	mergePrefix := "merge"
-	aPrefix := strconv.Itoa(a) + "-"
-	bPrefix := strconv.Itoa(b) + "-"
-
-	aPath := path.Join(dbDir, aPrefix+ssTableDataFileName)
-	aIt, err := newDataFileIterator(aPath)
-	if err != nil {
-		return fmt.Errorf("failed to instantiate for %s: %w", aPath, err)
-	}
+// @Update 2026-10-16
 
-	bPath := path.Join(dbDir, bPrefix+ssTableDataFileName)
-	bIt, err := newDataFileIterator(bPath)
-	if err != nil {
-		return fmt.Errorf("failed to iterator for %s: %w", bPath, err)
-	}
+// recordIterator is the forward iterator over the sorted records used by merge.
+type recordIterator interface {
+	// hasNext returns true if there is next element.
+	hasNext() bool
+	// next returns the current record and advances the iterator position.
+	next() (entry, error)
+	// close closes associated resources.
+	close() error
+}
 
-	writer, err := newSsTableWriter(dbDir, mergePrefix, sparseKeyDistance)
-	if err != nil {
-		return fmt.Errorf("failed to instantiate sstable writer: %w", err)
-	}
+// recordWriter is the writer of the sorted records used by merge.
+type recordWriter interface {
+	// write writes the record.
+	write(e entry) error
+}
 
-	if err := merge(aIt, bIt, writer); err != nil {
-		return fmt.Errorf("failed tomerge sstable: %w", err)
-	}
+// mergeOptions are the options of merge.
+type mergeOptions struct {
+	// snapshots are the live snapshots, the records visible to them are kept.
+	snapshots snapshotList
+	// rangeDels are the range tombstones, the records deleted by them are dropped.
+	rangeDels rangeDelIndex
+	// bottommost returns true if there are no older records of the key
+	// outside the merged ones, so the tombstone of the key may be dropped.
+	// The nil function means the tombstones are always kept.
+	bottommost func(key []byte) bool
+	// now is the current time in Unix nanoseconds, the values expired by
+	// then are replaced with the tombstones. 0 means the values never expire.
+	now int64
+}
 
-	if err := aIt.close(); err != nil {
-		return fmt.Errorf("failed to close iterator for %s: %w", aPath, err)
+// mergeStats describes the records dropped by merge.
+type mergeStats struct {
+	// purged is the number of the dropped tombstones.
+	purged int
+	// expired is the number of the dropped expired values.
+	expired int
+}
+
+// merge merges keys and values from the iterators and writes them into
+// the SSTable using SSTable writer. When the same key is found several times
+// the record with the greatest sequence number is written, the older records
+// are written only if they are visible to the given snapshots. The records
+// deleted by the range tombstones are dropped unless they are visible to the
+// snapshots taken before the deletion. The iterators are ordered from the
+// oldest to the newest, so the record of the newest one wins among the records
+// with the same sequence number, which are written by the older version without
+// sequence numbers.
+//
+// The expired value is invisible to all readers, so it is replaced with the
+// tombstone. The tombstone is dropped together with the older records it
+// shadows if the records are bottommost and no snapshot taken before the
+// deletion exists.
+func merge(its []recordIterator, writer recordWriter, options mergeOptions) (mergeStats, error) {
+	h := make(mergeHeap, 0, len(its))
+	for i, it := range its {
+		if err := h.pushNext(i, it); err != nil {
+			return mergeStats{}, err
+		}
 	}
 
-	if err := bIt.close(); err != nil {
-		return fmt.Errorf("failed to close iterator for %s: %w", bPath, err)
+	var stats mergeStats
+	var lastKey []byte
+	var lastSeq uint64
+	for h.Len() > 0 {
+		item := heap.Pop(&h).(mergeItem)
+		// next is the sequence number since which the record is not visible.
+		next := uint64(maxSequence + 1)
+		if lastKey != nil && bytes.Equal(item.key, lastKey) {
+			next = lastSeq
+		}
+		if deleted := options.rangeDels.minCovering(item.key, item.seq); deleted > 0 && deleted < next {
+			next = deleted
+		}
+
+		visible := next > maxSequence || options.snapshots.visible(item.seq, next)
+		if visible && item.expired(options.now) {
+			item.value, item.expiresAt = nil, 0
+			stats.expired++
+		}
+		// the older records are invisible to all snapshots if the tombstone is
+		// visible to all of them, so they are dropped too.
+		if visible && item.value == nil && options.bottommost != nil &&
+			!options.snapshots.visible(0, item.seq) && options.bottommost(item.key) {
+			visible = false
+			stats.purged++
+		}
+
+		if visible {
+			if err := writer.write(item.entry); err != nil {
+				return mergeStats{}, fmt.Errorf("failed to write: %w", err)
+			}
+		}
+		lastKey, lastSeq = item.key, item.seq
+
+		if err := h.pushNext(item.index, its[item.index]); err != nil {
+			return mergeStats{}, err
+		}
 	}
 
-	if err := writer.close(); err != nil {
-		return fmt.Errorf("failed to close writer: %w", err)
+	return stats, nil
+}
+
+// mergeItem is the current record of the merged iterator.
+type mergeItem struct {
+	entry
+	// index is the position of the iterator, the greater one is newer.
+	index int
+}
+
+// mergeHeap is the min heap of the current records ordered by key. Among the
+// records with the same key the one with the greater sequence number goes
+// first, then the record of the newer iterator.
+type mergeHeap []mergeItem
+
+// pushNext pushes the next record of the iterator, if any, into the heap.
+func (h *mergeHeap) pushNext(index int, it recordIterator) error {
+	if !it.hasNext() {
+		return nil
 	}
 
-	if err := deleteSsTable(dbDir, aPrefix, bPrefix); err != nil {
-		return fmt.Errorf("failed to delete sstable: %w", err)
+	e, err := it.next()
+	if err != nil {
+		return fmt.Errorf("failed to get next for iterator %d: %w", index, err)
 	}
+	heap.Push(h, mergeItem{entry: e, index: index})
+	return nil
+}
+
+func (h mergeHeap) Len() int { return len(h) }
 
-	if err := renameSsTable(dbDir, mergePrefix, bPrefix); err != nil {
-		return fmt.Errorf("failed to rename sstable: %w", err)
+func (h mergeHeap) Less(i, j int) bool {
+	if cmp := bytes.Compare(h[i].key, h[j].key); cmp != 0 {
+		return cmp < 0
 	}
+	if h[i].seq != h[j].seq {
+		return h[i].seq > h[j].seq
+	}
+	return h[i].index > h[j].index
+}
 
-	return nil
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *mergeHeap) Push(x interface{}) { *h = append(*h, x.(mergeItem)) }
+
+func (h *mergeHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
 }
 
-// merge merges keys and values from a and b iterators and writs them
-// into the SSTable using SStable writer.
-func merge(aIt, bIt *dataFileIterator, writer *ssTableWriter) error {
-	var aKey, aValue, bKey, bValue []byte
-	for {
-		if aKey == nil && aIt.hasNext() {
-			if k, v, err := aIt.next(); err != nil {
-				return fmt.Errorf("failed to get next for a: %w", err)
-			} else {
-				aKey, aValue = k, v
-			}
-		}
+// tableIterator allows simple iteration over the records of SSTable.
+// The data blocks are read one by one, so their checksums are verified.
+type tableIterator struct {
+	// cache shares the reader of the table, it is released by close.
+	cache      *tableCache
+	cached     *cachedTable
+	table      *ssTableReader
+	blockIndex int
+	entries    []entry
+	closed     bool
+}
 
-		if bKey == nil && bIt.hasNext() {
-			if k, v, err := bIt.next(); err != nil {
-				return fmt.Errorf("failed to get next for b: %w", err)
-			} else {
-				bKey, bValue = k, v
-			}
-		}
+// newTableIterator instantiates new iterator over the records of the specific
+// SSTable. The reader is taken from the cache.
+func newTableIterator(cache *tableCache, index int) (*tableIterator, error) {
+	cached, err := cache.acquire(index)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sstable %d: %w", index, err)
+	}
 
-		if aKey == nil && bKey == nil && !aIt.hasNext() && !bIt.hasNext() {
-			return nil
-		}
+	it := &tableIterator{cache: cache, cached: cached, table: cached.reader, blockIndex: -1}
+	if err := it.skipEmpty(); err != nil {
+		_ = it.close()
+		return nil, err
+	}
+	return it, nil
+}
 
-		if aKey != nil && bKey != nil {
-			cmp := bytes.Compare(aKey, bKey)
-			if cmp == 0 {
-				// aKey == bKey, ignore aKey since bKey is newer.
-				if err := writer.write(bKey, bValue); err != nil {
-					return fmt.Errorf("failed to write: %w", err)
-				}
-				aKey, aValue, bKey, bValue = nil, nil, nil, nil
-			} else if cmp > 0 {
-				if err := writer.write(bKey, bValue); err != nil {
-					return fmt.Errorf("failed to write: %w", err)
-				}
-				bKey, bValue = nil, nil
-			} else if cmp < 0 {
-				if err := writer.write(aKey, aValue); err != nil {
-					return fmt.Errorf("failed to write: %w", err)
-				}
-				aKey, aValue = nil, nil
-			}
-		} else if aKey != nil {
-			if err := writer.write(aKey, aValue); err != nil {
-				return fmt.Errorf("failed to write: %w", err)
-			}
-			aKey, aValue = nil, nil
-		} else {
-			if err := writer.write(bKey, bValue); err != nil {
-				return fmt.Errorf("failed to write: %w", err)
-			}
-			bKey, bValue = nil, nil
+// skipEmpty reads the next blocks until the one with records is found.
+func (it *tableIterator) skipEmpty() error {
+	for len(it.entries) == 0 && it.blockIndex+1 < len(it.table.blocks) {
+		it.blockIndex++
+		entries, err := it.table.readDataBlock(it.blockIndex)
+		if err != nil {
+			return fmt.Errorf("failed to read block %d: %w", it.blockIndex, err)
 		}
+		it.entries = entries
 	}
+
+	return nil
 }
 
-// dataFileIterator allows simple iteration over the data file.
-type dataFileIterator struct {
-	dataFile *os.File
-	key      []byte
-	value    []byte
-	end      bool
-	closed   bool
+// hasNext returns true if there is next element.
+func (it *tableIterator) hasNext() bool {
+	return len(it.entries) > 0
 }
 
-// newDataFileIterator instantiates new data file iterator.
-func newDataFileIterator(path string) (*dataFileIterator, error) {
-	dataFile, err := os.OpenFile(path, os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open data file %s: %w", path, err)
+// next returns the current record and advances the iterator position.
+func (it *tableIterator) next() (entry, error) {
+	e := it.entries[0]
+	it.entries = it.entries[1:]
+
+	if err := it.skipEmpty(); err != nil {
+		return entry{}, err
 	}
+	return e, nil
+}
 
-	key, value, err := decode(dataFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read: %w", err)
+// close releases the reader of the table.
+func (it *tableIterator) close() error {
+	if it.closed {
+		return nil
 	}
 
-	end := err == io.EOF
-	return &dataFileIterator{
-		dataFile: dataFile,
-		key:      key,
-		value:    value,
-		end:      end,
-		closed:   false,
-	}, nil
+	it.cache.release(it.cached)
+	it.closed = true
+	return nil
 }
 
-// hasNext returns true if there is next element.
-func (it *dataFileIterator) hasNext() bool {
-	return !it.end
+// concatIterator iterates over several SSTables one after another.
+// The tables must not overlap and must be ordered by keys.
+type concatIterator struct {
+	cache   *tableCache
+	tables  []*tableMeta
+	current *tableIterator
+}
+
+// newConcatIterator instantiates new iterator over the given tables.
+func newConcatIterator(cache *tableCache, tables []*tableMeta) (*concatIterator, error) {
+	it := &concatIterator{cache: cache, tables: tables}
+	if err := it.skipEmpty(); err != nil {
+		return nil, err
+	}
+	return it, nil
 }
 
-// next returns the current key and value and advances the iterator position.
-func (it *dataFileIterator) next() ([]byte, []byte, error) {
-	key, value := it.key, it.value
+// skipEmpty opens the next tables until the one with records is found.
+func (it *concatIterator) skipEmpty() error {
+	for (it.current == nil || !it.current.hasNext()) && len(it.tables) > 0 {
+		if err := it.close(); err != nil {
+			return err
+		}
 
-	nextKey, nextValue, err := decode(it.dataFile)
-	if err != nil {
-		if err == io.EOF {
-			it.end = true
-		} else {
-			return nil, nil, fmt.Errorf("failed to read: %w", err)
+		current, err := newTableIterator(it.cache, it.tables[0].id)
+		if err != nil {
+			return err
 		}
+		it.current, it.tables = current, it.tables[1:]
 	}
 
-	it.key = nextKey
-	it.value = nextValue
+	return nil
+}
 
-	return key, value, nil
+// hasNext returns true if there is next element.
+func (it *concatIterator) hasNext() bool {
+	return it.current != nil && it.current.hasNext()
 }
 
-// close closes associated file.
-func (it *dataFileIterator) close() error {
-	if it.closed {
-		return nil
+// next returns the current record and advances the iterator position.
+func (it *concatIterator) next() (entry, error) {
+	e, err := it.current.next()
+	if err != nil {
+		return entry{}, err
 	}
 
-	if err := it.dataFile.Close(); err != nil {
-		return fmt.Errorf("failed to close: %w", err)
+	if err := it.skipEmpty(); err != nil {
+		return entry{}, err
 	}
+	return e, nil
+}
 
-	it.closed = true
-	return nil
+// close closes the current data file.
+func (it *concatIterator) close() error {
+	if it.current == nil {
+		return nil
+	}
+	return it.current.close()
 }
@@ -0,0 +1,75 @@
+package lsmtree
+
+import (
+	"bytes"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// sliceRecordIterator iterates over the records in memory.
+type sliceRecordIterator struct {
+	entries []entry
+}
+
+func (it *sliceRecordIterator) hasNext() bool {
+	return len(it.entries) > 0
+}
+
+func (it *sliceRecordIterator) next() (entry, error) {
+	e := it.entries[0]
+	it.entries = it.entries[1:]
+	return e, nil
+}
+
+func (it *sliceRecordIterator) close() error {
+	return nil
+}
+
+// sliceRecordWriter collects the written records in memory.
+type sliceRecordWriter struct {
+	entries []entry
+}
+
+func (w *sliceRecordWriter) write(e entry) error {
+	w.entries = append(w.entries, e)
+	return nil
+}
+
+func TestMerge(t *testing.T) {
+	records := func(kvs ...string) *sliceRecordIterator {
+		it := &sliceRecordIterator{}
+		for i := 0; i < len(kvs); i += 2 {
+			var value []byte
+			if kvs[i+1] != "" {
+				value = []byte(kvs[i+1])
+			}
+			it.entries = append(it.entries, entry{key: []byte(kvs[i]), value: value})
+		}
+		return it
+	}
+
+	writer := &sliceRecordWriter{}
+	its := []recordIterator{
+		records("a", "1", "b", "1", "d", "1", "f", "1"),
+		records(),
+		records("b", "2", "c", "2", "f", ""),
+		records("a", "3", "c", "3", "e", "3"),
+	}
+	if _, err := merge(its, writer, mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := records("a", "3", "b", "2", "c", "3", "d", "1", "e", "3", "f", "").entries
+	if len(writer.entries) != len(expected) {
+		t.Fatalf("merge expected %d records, actual %d", len(expected), len(writer.entries))
+	}
+	for i, e := range expected {
+		actual := writer.entries[i]
+		if !bytes.Equal(e.key, actual.key) || !bytes.Equal(e.value, actual.value) || (e.value == nil) != (actual.value == nil) {
+			t.Fatalf("merge expected record %d key=%s value=%s, actual key=%s value=%s",
+				i, e.key, e.value, actual.key, actual.value)
+		}
+	}
+}
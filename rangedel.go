@@ -0,0 +1,234 @@
+package lsmtree
+
+import (
+	"bytes"
+	"sort"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// rangeTombstone is the deletion of all keys in range [start, end) written with
+// the sequence number seq. It deletes only the versions of the keys written
+// before it, the later writes of the keys in the range are visible.
+//
+// The tombstones are kept in MemTable until it is flushed, then they are kept
+// in the version, so every lookup sees all tombstones regardless of the tables
+// which contain the covered keys.
+type rangeTombstone struct {
+	start []byte
+	end   []byte
+	seq   uint64
+}
+
+// contains returns true if the key is in the range of the tombstone.
+func (r rangeTombstone) contains(key []byte) bool {
+	return bytes.Compare(r.start, key) <= 0 && bytes.Compare(key, r.end) < 0
+}
+
+// overlaps returns true if the tombstone contains keys in range [smallest, largest].
+func (r rangeTombstone) overlaps(smallest, largest []byte) bool {
+	return bytes.Compare(r.start, largest) <= 0 && bytes.Compare(smallest, r.end) < 0
+}
+
+// rangeTombstones is the list of range tombstones in the order they are written.
+type rangeTombstones []rangeTombstone
+
+// rangeFragment is the part [start, end) of the key space which is contained
+// by the same range tombstones.
+type rangeFragment struct {
+	start []byte
+	end   []byte
+	// seqs are the sequence numbers of the tombstones in ascending order.
+	seqs []uint64
+}
+
+// rangeDelIndex is the range tombstones split into the non-overlapping fragments
+// ordered by start key, so the tombstones containing the key are found by the
+// binary search instead of checking every tombstone.
+type rangeDelIndex []rangeFragment
+
+// newRangeDelIndex creates the index of the tombstones. The fragments are split
+// at the start and end keys of all tombstones, the parts of the key space which
+// no tombstone contains are not kept.
+func newRangeDelIndex(l rangeTombstones) rangeDelIndex {
+	if len(l) == 0 {
+		return nil
+	}
+
+	bounds := make([][]byte, 0, 2*len(l))
+	for _, r := range l {
+		bounds = append(bounds, r.start, r.end)
+	}
+	sort.Slice(bounds, func(i, j int) bool { return bytes.Compare(bounds[i], bounds[j]) < 0 })
+
+	sorted := append(make(rangeTombstones, 0, len(l)), l...)
+	sort.Slice(sorted, func(i, j int) bool { return bytes.Compare(sorted[i].start, sorted[j].start) < 0 })
+
+	var idx rangeDelIndex
+	var active rangeTombstones
+	next := 0
+	for i := 0; i+1 < len(bounds); i++ {
+		start, end := bounds[i], bounds[i+1]
+		if bytes.Equal(start, end) {
+			continue
+		}
+
+		// active are the tombstones containing start, they contain the whole
+		// fragment as no tombstone starts or ends inside of it.
+		for ; next < len(sorted) && bytes.Compare(sorted[next].start, start) <= 0; next++ {
+			active = append(active, sorted[next])
+		}
+		kept := active[:0]
+		for _, r := range active {
+			if bytes.Compare(start, r.end) < 0 {
+				kept = append(kept, r)
+			}
+		}
+		active = kept
+		if len(active) == 0 {
+			continue
+		}
+
+		seqs := make([]uint64, 0, len(active))
+		for _, r := range active {
+			seqs = append(seqs, r.seq)
+		}
+		sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+		idx = append(idx, rangeFragment{start: start, end: end, seqs: seqs})
+	}
+	return idx
+}
+
+// insert returns the index with the tombstone added, the index itself is not
+// changed, so it may still be used without mu. Only the fragments overlapping
+// the tombstone are split and copied, the other ones are shared.
+func (idx rangeDelIndex) insert(r rangeTombstone) rangeDelIndex {
+	if bytes.Compare(r.start, r.end) >= 0 {
+		return idx
+	}
+
+	from := sort.Search(len(idx), func(i int) bool { return bytes.Compare(r.start, idx[i].end) < 0 })
+	to := sort.Search(len(idx), func(i int) bool { return bytes.Compare(idx[i].start, r.end) >= 0 })
+
+	var fragments []rangeFragment
+	pos := r.start
+	for _, f := range idx[from:to] {
+		if bytes.Compare(f.start, pos) < 0 {
+			// the fragment starts before the tombstone.
+			fragments = append(fragments, rangeFragment{start: f.start, end: pos, seqs: f.seqs})
+		} else if bytes.Compare(pos, f.start) < 0 {
+			// no fragment contains the keys before this one.
+			fragments = append(fragments, rangeFragment{start: pos, end: f.start, seqs: []uint64{r.seq}})
+			pos = f.start
+		}
+
+		end := f.end
+		if bytes.Compare(r.end, end) < 0 {
+			end = r.end
+		}
+		i := sort.Search(len(f.seqs), func(i int) bool { return f.seqs[i] > r.seq })
+		seqs := make([]uint64, 0, len(f.seqs)+1)
+		seqs = append(append(append(seqs, f.seqs[:i]...), r.seq), f.seqs[i:]...)
+		fragments = append(fragments, rangeFragment{start: pos, end: end, seqs: seqs})
+
+		if bytes.Compare(end, f.end) < 0 {
+			// the fragment ends after the tombstone.
+			fragments = append(fragments, rangeFragment{start: end, end: f.end, seqs: f.seqs})
+		}
+		pos = end
+	}
+	if bytes.Compare(pos, r.end) < 0 {
+		fragments = append(fragments, rangeFragment{start: pos, end: r.end, seqs: []uint64{r.seq}})
+	}
+
+	inserted := make(rangeDelIndex, 0, len(idx)-(to-from)+len(fragments))
+	inserted = append(inserted, idx[:from]...)
+	inserted = append(inserted, fragments...)
+	return append(inserted, idx[to:]...)
+}
+
+// fragment returns the fragment containing the key, or nil if there is none.
+func (idx rangeDelIndex) fragment(key []byte) *rangeFragment {
+	i := sort.Search(len(idx), func(i int) bool { return bytes.Compare(key, idx[i].end) < 0 })
+	if i == len(idx) || bytes.Compare(idx[i].start, key) > 0 {
+		return nil
+	}
+	return &idx[i]
+}
+
+// overlaps returns true if any tombstone contains keys in range [smallest, largest].
+func (idx rangeDelIndex) overlaps(smallest, largest []byte) bool {
+	i := sort.Search(len(idx), func(i int) bool { return bytes.Compare(smallest, idx[i].end) < 0 })
+	return i < len(idx) && bytes.Compare(idx[i].start, largest) <= 0
+}
+
+// maxCovering returns the greatest sequence number of the tombstones containing
+// the key which is not greater than the given one, or 0 if there is none.
+func (idx rangeDelIndex) maxCovering(key []byte, seq uint64) uint64 {
+	f := idx.fragment(key)
+	if f == nil {
+		return 0
+	}
+
+	i := sort.Search(len(f.seqs), func(i int) bool { return f.seqs[i] > seq })
+	if i == 0 {
+		return 0
+	}
+	return f.seqs[i-1]
+}
+
+// minCovering returns the smallest sequence number of the tombstones containing
+// the key which is greater than the given one, or 0 if there is none. It is the
+// sequence number since which the version of the key with the given sequence
+// number is deleted.
+func (idx rangeDelIndex) minCovering(key []byte, seq uint64) uint64 {
+	f := idx.fragment(key)
+	if f == nil {
+		return 0
+	}
+
+	i := sort.Search(len(f.seqs), func(i int) bool { return f.seqs[i] > seq })
+	if i == len(f.seqs) {
+		return 0
+	}
+	return f.seqs[i]
+}
+
+// rangeDelView is the range tombstones of the tree visible as of the write
+// with the sequence number seq. It refers to the indexes of the MemTables and
+// the version, which are never changed but replaced, so it is valid without mu.
+type rangeDelView struct {
+	indexes [3]rangeDelIndex
+	seq     uint64
+}
+
+// maxCovering returns the greatest sequence number of the visible tombstones
+// containing the key, or 0 if there is none.
+func (v rangeDelView) maxCovering(key []byte) uint64 {
+	covering := uint64(0)
+	for _, idx := range v.indexes {
+		if seq := idx.maxCovering(key, v.seq); seq > covering {
+			covering = seq
+		}
+	}
+	return covering
+}
+
+// covers returns true if the version of the key with the given sequence
+// number is deleted by any visible tombstone.
+func (v rangeDelView) covers(key []byte, seq uint64) bool {
+	return v.maxCovering(key) > seq
+}
+
+// rangeTombstones returns all range tombstones of the tree visible as of the
+// write with the given sequence number. The caller must hold mu.
+func (t *LSMTree) rangeTombstones(seq uint64) rangeDelView {
+	view := rangeDelView{seq: seq}
+	view.indexes[0] = t.mt.rangeDelIndex
+	if t.imm != nil {
+		view.indexes[1] = t.imm.rangeDelIndex
+	}
+	view.indexes[2] = t.version.rangeDelIndex
+	return view
+}
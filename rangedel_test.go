@@ -0,0 +1,304 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestWriteBatch_DeleteRange(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("b"))
+	batch.DeleteRange([]byte("c"), []byte("d"))
+	batch.seq = 5
+
+	data, err := encodeBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeBatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.seq != 5 || decoded.Len() != 3 {
+		t.Fatalf("decodeBatch expected seq=5 len=3, actual seq=%d len=%d", decoded.seq, decoded.Len())
+	}
+	for i, e := range decoded.entries {
+		if e.kind() != batch.entries[i].kind() || string(e.key) != string(batch.entries[i].key) ||
+			string(e.value) != string(batch.entries[i].value) {
+			t.Fatalf("decodeBatch entry %d expected %v, actual %v", i, batch.entries[i], e)
+		}
+	}
+
+	tooLarge := make([]byte, MaxKeySize+1)
+	cases := []struct {
+		start, end []byte
+		err        error
+	}{
+		{[]byte("b"), []byte("a"), ErrInvalidRange},
+		{[]byte("a"), []byte("a"), ErrInvalidRange},
+		{nil, []byte("a"), ErrKeyRequired},
+		{[]byte("a"), tooLarge, ErrKeyTooLarge},
+	}
+	for _, c := range cases {
+		batch := NewWriteBatch()
+		batch.DeleteRange(c.start, c.end)
+		if err := batch.validate(); err != c.err {
+			t.Fatalf("validate range [%q, %q) expected err=%v, actual err=%v", c.start, c.end, c.err, err)
+		}
+	}
+}
+
+func TestRangeDelIndex(t *testing.T) {
+	l := rangeTombstones{
+		{start: []byte("c"), end: []byte("g"), seq: 5},
+		{start: []byte("a"), end: []byte("e"), seq: 3},
+		{start: []byte("e"), end: []byte("f"), seq: 7},
+		{start: []byte("j"), end: []byte("k"), seq: 2},
+	}
+	idx := newRangeDelIndex(l)
+	for i := 1; i < len(idx); i++ {
+		if bytes.Compare(idx[i-1].end, idx[i].start) > 0 {
+			t.Fatalf("the fragments must not overlap, actual %v", idx)
+		}
+	}
+
+	// the index must find the same tombstones as checking every one of them.
+	for _, key := range []string{"", "a", "b", "c", "d", "e", "f", "g", "h", "j", "jj", "k", "z"} {
+		for seq := uint64(0); seq <= 8; seq++ {
+			maxCovering, minCovering := uint64(0), uint64(0)
+			for _, r := range l {
+				if !r.contains([]byte(key)) {
+					continue
+				}
+				if r.seq <= seq && r.seq > maxCovering {
+					maxCovering = r.seq
+				}
+				if r.seq > seq && (minCovering == 0 || r.seq < minCovering) {
+					minCovering = r.seq
+				}
+			}
+
+			if actual := idx.maxCovering([]byte(key), seq); actual != maxCovering {
+				t.Fatalf("maxCovering key=%s seq=%d expected %d, actual %d", key, seq, maxCovering, actual)
+			}
+			if actual := idx.minCovering([]byte(key), seq); actual != minCovering {
+				t.Fatalf("minCovering key=%s seq=%d expected %d, actual %d", key, seq, minCovering, actual)
+			}
+		}
+	}
+
+	cases := []struct {
+		smallest, largest string
+		overlaps          bool
+	}{
+		{"0", "a", true},
+		{"f", "f", true},
+		{"g", "i", false},
+		{"h", "j", true},
+		{"k", "z", false},
+	}
+	for _, c := range cases {
+		if actual := idx.overlaps([]byte(c.smallest), []byte(c.largest)); actual != c.overlaps {
+			t.Fatalf("overlaps [%s, %s] expected %v, actual %v", c.smallest, c.largest, c.overlaps, actual)
+		}
+	}
+}
+
+func TestRangeDelIndex_insert(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	key := func() []byte {
+		return []byte{byte('a' + rnd.Intn(20))}
+	}
+
+	// the index built by the inserts must find the same tombstones as the
+	// one built at once.
+	for round := 0; round < 100; round++ {
+		var l rangeTombstones
+		var idx rangeDelIndex
+		for i := 0; i < 20; i++ {
+			r := rangeTombstone{start: key(), end: key(), seq: uint64(rnd.Intn(50))}
+			previous := fmt.Sprint(idx)
+			l = append(l, r)
+			inserted := idx.insert(r)
+			if fmt.Sprint(idx) != previous {
+				t.Fatalf("insert must not change the index")
+			}
+			idx = inserted
+
+			for j := 1; j < len(idx); j++ {
+				if bytes.Compare(idx[j-1].end, idx[j].start) > 0 {
+					t.Fatalf("the fragments must not overlap, actual %v", idx)
+				}
+			}
+			expected := newRangeDelIndex(l)
+			for k := byte('a' - 1); k <= 'a'+20; k++ {
+				for seq := uint64(0); seq <= 50; seq++ {
+					if e, a := expected.maxCovering([]byte{k}, seq), idx.maxCovering([]byte{k}, seq); e != a {
+						t.Fatalf("maxCovering key=%c seq=%d expected %d, actual %d", k, seq, e, a)
+					}
+					if e, a := expected.minCovering([]byte{k}, seq), idx.minCovering([]byte{k}, seq); e != a {
+						t.Fatalf("minCovering key=%c seq=%d expected %d, actual %d", k, seq, e, a)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestLSMTree_DeleteRange(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// all keys are kept in the MemTable until the flush, so the compaction
+	// merges all of them.
+	tree, err := Open(dbDir, SparseKeyDistance(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = tree.Close() }()
+
+	if err := tree.Delete(make([]byte, MaxKeySize+1)); err != ErrKeyTooLarge {
+		t.Fatalf("Delete expected err=%v, actual err=%v", ErrKeyTooLarge, err)
+	}
+	if err := tree.DeleteRange([]byte("b"), []byte("a")); err != ErrInvalidRange {
+		t.Fatalf("DeleteRange expected err=%v, actual err=%v", ErrInvalidRange, err)
+	}
+
+	putRange(t, tree, 0, 100)
+	snapshot := tree.NewSnapshot()
+	if err := tree.DeleteRange([]byte("key-0020"), []byte("key-0050")); err != nil {
+		t.Fatal(err)
+	}
+	// the key written after the deletion is visible.
+	if err := tree.Put([]byte("key-0030"), []byte("key-0030")); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func() {
+		t.Helper()
+		checkRange(t, tree, 0, 20)
+		checkRange(t, tree, 30, 31)
+		checkRange(t, tree, 50, 100)
+		for i := 20; i < 50; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if _, ok, err := tree.Get(key); err != nil || ok != (i == 30) {
+				t.Fatalf("Get key=%s, unexpected ok=%v err=%v", key, ok, err)
+			}
+		}
+
+		it, err := tree.NewIterator(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		count := 0
+		for ; it.Valid(); count++ {
+			if err := it.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count != 71 {
+			t.Fatalf("iterator expected 71 keys, actual %d", count)
+		}
+		if err := it.Last(); err != nil {
+			t.Fatal(err)
+		}
+		for count = 0; it.Valid(); count++ {
+			if err := it.Prev(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count != 71 {
+			t.Fatalf("reverse iterator expected 71 keys, actual %d", count)
+		}
+	}
+
+	check()
+	if value, ok, err := snapshot.Get([]byte("key-0040")); err != nil || !ok || string(value) != "key-0040" {
+		t.Fatalf("the snapshot must see the deleted key, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// the flushed range tombstone is kept in the MANIFEST.
+	snapshot.Release()
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = Open(dbDir, SparseKeyDistance(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	check()
+
+	// the deleted keys are dropped by the compaction.
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	tree.mu.RLock()
+	tables := tree.version.levels[levelNum-1]
+	tree.mu.RUnlock()
+	it, err := newConcatIterator(tree.tableCache, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.close()
+	records := 0
+	for ; it.hasNext(); records++ {
+		if _, err := it.next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records != 71 {
+		t.Fatalf("the deleted keys must be dropped, expected 71 records, actual %d", records)
+	}
+}
+
+func TestLSMTree_DeleteRangeRecovery(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 10)
+	if err := tree.DeleteRange([]byte("key-0002"), []byte("key-0005")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the range tombstone is replayed from the WAL.
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 2)
+	checkRange(t, tree, 5, 10)
+	for i := 2; i < 5; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if _, ok, err := tree.Get(key); err != nil || ok {
+			t.Fatalf("Get key=%s expected the deleted key, actual ok=%v err=%v", key, ok, err)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package lsmtree
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// WALRecoveryMode decides how the torn and corrupted WAL records are handled
+// when the database is opened.
+type WALRecoveryMode int
+
+const (
+	// TolerateCorruptedTailRecords is default mode. The torn record at the end of
+	// the last WAL file, which is left by the crash during the write, is dropped
+	// and the file is truncated. The corrupted record is dropped the same way if
+	// it is followed only by zeros, otherwise Open fails with CorruptionError.
+	// The torn record at the end of the older WAL files is the corruption too.
+	TolerateCorruptedTailRecords WALRecoveryMode = iota
+	// AbsoluteConsistency fails Open with CorruptionError if any record is
+	// torn or corrupted.
+	AbsoluteConsistency
+	// SkipAnyCorruptedRecords skips the corrupted records and recovers all the
+	// valid ones. If the length of the corrupted record is corrupted too, the
+	// next record cannot be found, so the rest of the file is truncated.
+	SkipAnyCorruptedRecords
+)
+
+// RecoveryStats describes the outcome of WAL recovery when the database is opened.
+type RecoveryStats struct {
+	// Records is the number of recovered records.
+	Records int
+	// SkippedRecords is the number of skipped corrupted records.
+	SkippedRecords int
+	// TruncatedBytes is the number of bytes truncated from the end of WAL files.
+	// In read-only mode they are dropped, but the files are not changed.
+	TruncatedBytes int64
+	// Corruptions are the torn and corrupted records tolerated by the recovery mode.
+	Corruptions []*CorruptionError
+
+	// unsequenced is the number of the recovered records written by the older
+	// version without sequence numbers, they are numbered by the recovery.
+	unsequenced int
+}
+
+// WALRecovery sets the WAL recovery mode for LSMTree.
+func WALRecovery(mode WALRecoveryMode) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.walRecoveryMode = mode
+	}
+}
+
+// RecoveryStats returns the outcome of WAL recovery done by Open.
+func (t *LSMTree) RecoveryStats() RecoveryStats {
+	return t.recoveryStats
+}
@@ -0,0 +1,164 @@
+package lsmtree
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestWALRecovery(t *testing.T) {
+	torn := func(data []byte, recordSize int) []byte {
+		return data[:len(data)-3]
+	}
+	corruptMiddle := func(data []byte, recordSize int) []byte {
+		data[recordSize+walHeaderSize+1] ^= 0xff
+		return data
+	}
+	corruptTail := func(data []byte, recordSize int) []byte {
+		data[2*recordSize+walHeaderSize+1] ^= 0xff
+		return append(data, make([]byte, 100)...)
+	}
+
+	cases := []struct {
+		name    string
+		damage  func(data []byte, recordSize int) []byte
+		mode    WALRecoveryMode
+		keys    []string
+		skipped int
+		fail    bool
+	}{
+		{"torn tail", torn, TolerateCorruptedTailRecords, []string{"a", "b"}, 0, false},
+		{"torn tail", torn, AbsoluteConsistency, nil, 0, true},
+		{"torn tail", torn, SkipAnyCorruptedRecords, []string{"a", "b"}, 0, false},
+		{"corrupted middle", corruptMiddle, TolerateCorruptedTailRecords, nil, 0, true},
+		{"corrupted middle", corruptMiddle, AbsoluteConsistency, nil, 0, true},
+		{"corrupted middle", corruptMiddle, SkipAnyCorruptedRecords, []string{"a", "c"}, 1, false},
+		{"corrupted tail", corruptTail, TolerateCorruptedTailRecords, []string{"a", "b"}, 0, false},
+		{"corrupted tail", corruptTail, SkipAnyCorruptedRecords, []string{"a", "b"}, 0, false},
+	}
+
+	for _, c := range cases {
+		dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dbDir)
+
+		tree, err := Open(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"a", "b", "c"} {
+			if err := tree.Put([]byte(key), []byte("v"+key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		walPath := walSegmentPath(dbDir, 0)
+		data, err := ioutil.ReadFile(walPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(walPath, c.damage(data, len(data)/3), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		tree, err = Open(dbDir, WALRecovery(c.mode))
+		if c.fail {
+			if !errors.Is(err, ErrCorruption) {
+				t.Fatalf("%s mode=%d: Open expected corruption error, actual err=%v", c.name, c.mode, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s mode=%d: Open error: %s", c.name, c.mode, err)
+		}
+
+		stats := tree.RecoveryStats()
+		if stats.Records != len(c.keys) || stats.SkippedRecords != c.skipped || len(stats.Corruptions) != 1 {
+			t.Fatalf("%s mode=%d: unexpected recovery stats %+v", c.name, c.mode, stats)
+		}
+		for _, key := range c.keys {
+			if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != "v"+key {
+				t.Fatalf("%s mode=%d: Get key=%s, unexpected value=%s ok=%v err=%v", c.name, c.mode, key, value, ok, err)
+			}
+		}
+
+		// the recovered WAL must accept new records and be readable again.
+		if err := tree.Put([]byte("d"), []byte("vd")); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+		tree, err = Open(dbDir, WALRecovery(c.mode))
+		if err != nil {
+			t.Fatalf("%s mode=%d: reopen error: %s", c.name, c.mode, err)
+		}
+		if _, ok, err := tree.Get([]byte("d")); err != nil || !ok {
+			t.Fatalf("%s mode=%d: Get after reopen expected ok=true, actual ok=%v err=%v", c.name, c.mode, ok, err)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestWALRecovery_TornOlderSegment(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir, WALSegmentSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 20)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := listWALSegments(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 2 {
+		t.Fatalf("the WAL must be rotated at the size limit, segments=%v", segments)
+	}
+	walPath := walSegmentPath(dbDir, segments[0])
+	data, err := ioutil.ReadFile(walPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(walPath, data[:len(data)-3], 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// the torn tail of the older segment is not left by the crash during the
+	// write, so the records after it must not be recovered silently.
+	for _, mode := range []WALRecoveryMode{TolerateCorruptedTailRecords, AbsoluteConsistency} {
+		if _, err := Open(dbDir, WALRecovery(mode)); !errors.Is(err, ErrCorruption) {
+			t.Fatalf("mode=%d: Open expected corruption error, actual err=%v", mode, err)
+		}
+	}
+
+	tree, err = Open(dbDir, WALRecovery(SkipAnyCorruptedRecords))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if stats := tree.RecoveryStats(); len(stats.Corruptions) != 1 || stats.Records != 19 {
+		t.Fatalf("unexpected recovery stats %+v", stats)
+	}
+	// the records of the newer segments are recovered.
+	checkRange(t, tree, 19, 20)
+}
@@ -0,0 +1,89 @@
+package lsmtree
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// recordKind is the kind of the record: the value, the expiring value or the
+// deletion of the key or the range of keys.
+type recordKind uint8
+
+const (
+	// kindDelete marks the deleted key.
+	kindDelete recordKind = iota
+	// kindPut marks the key with the value.
+	kindPut
+	// kindRangeDelete marks the deleted range of keys, it is used only in
+	// the write batch.
+	kindRangeDelete
+	// kindExpiringPut marks the key with the value which expires at the
+	// given time.
+	kindExpiringPut
+)
+
+const (
+	// trailerSize is the size of the encoded sequence number and kind.
+	trailerSize = 8
+	// maxSequence is the max sequence number, it takes 56 bits of the trailer.
+	maxSequence = 1<<56 - 1
+)
+
+// Every write is assigned the sequence number, which is greater than the
+// numbers of all previous writes. The internal key consists of the user key,
+// the sequence number and the kind. The records of the same user key are
+// ordered by the sequence number, the greater one is newer.
+
+// encodeInternalValue encodes the sequence number and the kind followed by
+// the expiration time, if any, and the value of the record. The nil value is
+// encoded as the deletion.
+// The function must be compatible with decodeInternalValue.
+//
+//	Encode format:
+//	[sequence number << 8 | kind][expiration time][value]
+//	The expiration time is encoded only for kindExpiringPut.
+func encodeInternalValue(e entry) []byte {
+	kind := e.kind()
+	size := trailerSize + len(e.value)
+	if kind == kindExpiringPut {
+		size += 8
+	}
+
+	buf := make([]byte, size)
+	binary.BigEndian.PutUint64(buf, e.seq<<8|uint64(kind))
+	if kind == kindExpiringPut {
+		binary.BigEndian.PutUint64(buf[trailerSize:], uint64(e.expiresAt))
+	}
+	copy(buf[size-len(e.value):], e.value)
+	return buf
+}
+
+// decodeInternalValue decodes the value, the sequence number and the expiration
+// time of the record, the key of the returned entry is not set. The value of
+// the deletion is nil.
+// The function must be compatible with encodeInternalValue.
+func decodeInternalValue(data []byte) (entry, error) {
+	if len(data) < trailerSize {
+		return entry{}, fmt.Errorf("the value is corrupted, bad size %d", len(data))
+	}
+
+	trailer := binary.BigEndian.Uint64(data)
+	seq, kind := trailer>>8, recordKind(trailer&0xff)
+	switch kind {
+	case kindDelete:
+		return entry{seq: seq}, nil
+	case kindPut:
+		return entry{value: data[trailerSize:], seq: seq}, nil
+	case kindExpiringPut:
+		if len(data) < trailerSize+8 {
+			return entry{}, fmt.Errorf("the value is corrupted, bad size %d", len(data))
+		}
+		expiresAt := int64(binary.BigEndian.Uint64(data[trailerSize:]))
+		return entry{value: data[trailerSize+8:], seq: seq, expiresAt: expiresAt}, nil
+	default:
+		return entry{}, fmt.Errorf("the value is corrupted, unknown kind %d", kind)
+	}
+}
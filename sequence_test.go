@@ -0,0 +1,127 @@
+package lsmtree
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestInternalValue(t *testing.T) {
+	for _, value := range [][]byte{[]byte("value"), {}, nil} {
+		decoded, err := decodeInternalValue(encodeInternalValue(entry{value: value, seq: 7}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if decoded.seq != 7 || !bytes.Equal(decoded.value, value) || (decoded.value == nil) != (value == nil) {
+			t.Fatalf("decodeInternalValue expected seq=7 value=%v, actual seq=%d value=%v", value, decoded.seq, decoded.value)
+		}
+	}
+
+	if _, err := decodeInternalValue([]byte("short")); err == nil {
+		t.Fatalf("decodeInternalValue must fail on the short value")
+	}
+}
+
+func TestMerge_sequence(t *testing.T) {
+	writer := &sliceRecordWriter{}
+	its := []recordIterator{
+		&sliceRecordIterator{entries: []entry{{key: []byte("a"), value: []byte("new"), seq: 9}}},
+		&sliceRecordIterator{entries: []entry{{key: []byte("a"), value: []byte("old"), seq: 3}}},
+	}
+	if _, err := merge(its, writer, mergeOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(writer.entries) != 1 || string(writer.entries[0].value) != "new" || writer.entries[0].seq != 9 {
+		t.Fatalf("merge must keep the record with the greater sequence number, actual %v", writer.entries)
+	}
+}
+
+func TestLSMTree_Sequence(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := NewWriteBatch()
+	batch.Put([]byte("a"), []byte("1"))
+	batch.Delete([]byte("a"))
+	batch.Put([]byte("a"), []byte("2"))
+	if err := tree.Write(batch); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "2" {
+		t.Fatalf("the later mutation of the batch must win, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if tree.lastSequence != 3 {
+		t.Fatalf("every mutation must get the sequence number, expected 3, actual %d", tree.lastSequence)
+	}
+
+	// the flushed WAL segments are removed, the sequence number is kept in the MANIFEST.
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tree.lastSequence != 3 {
+		t.Fatalf("the sequence number must be recovered, expected 3, actual %d", tree.lastSequence)
+	}
+	if err := tree.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if tree.lastSequence != 4 {
+		t.Fatalf("the sequence number must increase, expected 4, actual %d", tree.lastSequence)
+	}
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "3" {
+		t.Fatalf("Get expected value=3, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+}
+
+func TestLSMTree_LegacyBatch(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// the older version encoded the batch without the sequence number.
+	var data, record bytes.Buffer
+	data.Write(encodeInt(2))
+	for _, kv := range [][2]string{{"a", "1"}, {"a", "2"}} {
+		if _, err := encode([]byte(kv[0]), []byte(kv[1]), &data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	encodeRecord(data.Bytes(), &record)
+	if err := ioutil.WriteFile(path.Join(dbDir, legacyWalFileName), record.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "2" {
+		t.Fatalf("Get expected value=2, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if tree.lastSequence != 2 {
+		t.Fatalf("the legacy batch must be assigned the sequence numbers, expected 2, actual %d", tree.lastSequence)
+	}
+}
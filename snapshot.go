@@ -0,0 +1,130 @@
+package lsmtree
+
+import (
+	"sort"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// Snapshot is the consistent view of the tree at the moment of its creation,
+// the later writes are not visible through it. The versions of the keys which
+// are visible to the snapshot are kept by the flushes and the compactions until
+// it is released, so it must be released as soon as it is not needed.
+type Snapshot struct {
+	t *LSMTree
+	// seq is the sequence number of the last write visible to the snapshot.
+	seq uint64
+	// released is true if the snapshot is released, it is guarded by mu of the tree.
+	released bool
+}
+
+// NewSnapshot creates the snapshot of the current state of the tree.
+func (t *LSMTree) NewSnapshot() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := &Snapshot{t: t, seq: t.lastSequence}
+	t.snapshots.add(s.seq)
+	return s
+}
+
+// oldestSnapshot returns the sequence number of the oldest live snapshot,
+// or maxSequence if there is none.
+func (t *LSMTree) oldestSnapshot() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.snapshots.oldest()
+}
+
+// Get returns the value according to the key as of the snapshot creation.
+func (s *Snapshot) Get(key []byte) ([]byte, bool, error) {
+	s.t.mu.RLock()
+	released := s.released
+	s.t.mu.RUnlock()
+
+	if released {
+		return nil, false, ErrSnapshotReleased
+	}
+	return s.t.get(key, s.seq)
+}
+
+// NewIterator creates an iterator over the keys in range [start, end) as of
+// the snapshot creation, see LSMTree.NewIterator. The iterator may be used
+// after the snapshot is released, but it must be closed after use.
+func (s *Snapshot) NewIterator(start, end []byte) (*Iterator, error) {
+	s.t.mu.RLock()
+	if s.released {
+		s.t.mu.RUnlock()
+		return nil, ErrSnapshotReleased
+	}
+	it := s.t.newIterator(start, end, s.seq)
+	s.t.mu.RUnlock()
+
+	if err := it.openTables(); err != nil {
+		_ = it.Close()
+		return nil, err
+	}
+	return it, nil
+}
+
+// Release releases the snapshot, so the versions visible only to it may be
+// dropped by the compaction. It is safe to call Release several times.
+func (s *Snapshot) Release() {
+	s.t.mu.Lock()
+	defer s.t.mu.Unlock()
+
+	if !s.released {
+		s.released = true
+		s.t.snapshots.remove(s.seq)
+		// the last level tables may have the records needed only by it.
+		s.t.triggerCompaction()
+	}
+}
+
+// snapshotList is the sequence numbers of the live snapshots in ascending
+// order. The snapshots are created with the last sequence number, which
+// only grows, so the new ones are appended to the end.
+type snapshotList []uint64
+
+// add adds the snapshot with the given sequence number.
+func (l *snapshotList) add(seq uint64) {
+	*l = append(*l, seq)
+}
+
+// remove removes one snapshot with the given sequence number.
+func (l *snapshotList) remove(seq uint64) {
+	s := *l
+	i := sort.Search(len(s), func(i int) bool { return s[i] >= seq })
+	if i < len(s) && s[i] == seq {
+		*l = append(s[:i], s[i+1:]...)
+	}
+}
+
+// visible returns true if the version of the key with the given sequence
+// number is visible to any snapshot. next is the sequence number of the newer
+// version of the same key, which shadows this version for the later snapshots.
+func (l *snapshotList) visible(seq, next uint64) bool {
+	if l == nil {
+		return false
+	}
+
+	s := *l
+	i := sort.Search(len(s), func(i int) bool { return s[i] >= seq })
+	return i < len(s) && s[i] < next
+}
+
+// oldest returns the sequence number of the oldest snapshot, or maxSequence
+// if there is none.
+func (l *snapshotList) oldest() uint64 {
+	if len(*l) == 0 {
+		return maxSequence
+	}
+	return (*l)[0]
+}
+
+// clone returns the copy of the list.
+func (l *snapshotList) clone() snapshotList {
+	return append(snapshotList(nil), *l...)
+}
@@ -0,0 +1,175 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestSnapshotList_visible(t *testing.T) {
+	var l snapshotList
+	l.add(3)
+	l.add(7)
+	l.add(7)
+
+	cases := []struct {
+		seq, next uint64
+		visible   bool
+	}{
+		{1, 3, false},
+		{1, 4, true},
+		{3, 5, true},
+		{4, 7, false},
+		{5, 9, true},
+		{8, 10, false},
+	}
+	for _, c := range cases {
+		if visible := l.visible(c.seq, c.next); visible != c.visible {
+			t.Fatalf("visible seq=%d next=%d expected %v, actual %v", c.seq, c.next, c.visible, visible)
+		}
+	}
+
+	l.remove(7)
+	if !l.visible(5, 9) {
+		t.Fatalf("the snapshot must be removed only once")
+	}
+	l.remove(7)
+	l.remove(3)
+	if l.visible(0, maxSequence) {
+		t.Fatalf("all snapshots must be removed")
+	}
+}
+
+func TestMemTable_snapshots(t *testing.T) {
+	var snapshots snapshotList
+	mt := newMemTable()
+	mt.snapshots = &snapshots
+
+	key := []byte("key")
+	versions := func() []entry {
+		data, _ := mt.data.Get(key)
+		return decodeVersions(data)
+	}
+	mt.put(key, []byte("v1"), 1)
+	snapshots.add(1)
+	mt.put(key, []byte("v2"), 2)
+	mt.put(key, []byte("v3"), 3)
+
+	if versions := versions(); len(versions) != 2 {
+		t.Fatalf("only the versions visible to the snapshots must be kept, actual %v", versions)
+	}
+	if value, ok := mt.get(key, 1); !ok || string(value) != "v1" {
+		t.Fatalf("get seq=1 expected value=v1, actual value=%s ok=%v", value, ok)
+	}
+	if value, ok := mt.get(key, maxSequence); !ok || string(value) != "v3" {
+		t.Fatalf("get expected value=v3, actual value=%s ok=%v", value, ok)
+	}
+	if expected := len(key) + 2*2; mt.bytes() != expected {
+		t.Fatalf("expected mt size=%d, actual mt size=%d", expected, mt.bytes())
+	}
+
+	snapshots.remove(1)
+	mt.delete(key, 4)
+	if versions := versions(); len(versions) != 1 {
+		t.Fatalf("the versions of the released snapshot must be dropped, actual %v", versions)
+	}
+	if _, ok := mt.get(key, 0); ok {
+		t.Fatalf("get seq=0 must not find the key")
+	}
+}
+
+func TestLSMTree_Snapshot(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	putRange(t, tree, 0, 50)
+	snapshot := tree.NewSnapshot()
+	defer snapshot.Release()
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		var err error
+		if i%2 == 0 {
+			err = tree.Put(key, []byte("new"))
+		} else {
+			err = tree.Delete(key)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	putRange(t, tree, 50, 60)
+
+	checkSnapshot := func() {
+		t.Helper()
+		for i := 0; i < 60; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			value, ok, err := snapshot.Get(key)
+			if err != nil || ok != (i < 50) || (ok && string(value) != string(key)) {
+				t.Fatalf("snapshot Get key=%s, unexpected ok=%v value=%s err=%v", key, ok, value, err)
+			}
+		}
+
+		it, err := snapshot.NewIterator(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		count := 0
+		for ; it.Valid(); count++ {
+			if expected := fmt.Sprintf("key-%04d", count); string(it.Key()) != expected || string(it.Value()) != expected {
+				t.Fatalf("snapshot iterator expected key=%s, actual key=%s value=%s", expected, it.Key(), it.Value())
+			}
+			if err := it.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if count != 50 {
+			t.Fatalf("snapshot iterator expected 50 keys, actual %d", count)
+		}
+	}
+
+	checkSnapshot()
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkSnapshot()
+
+	if value, ok, err := tree.Get([]byte("key-0000")); err != nil || !ok || string(value) != "new" {
+		t.Fatalf("Get expected value=new, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if _, ok, err := tree.Get([]byte("key-0001")); err != nil || ok {
+		t.Fatalf("Get expected the deleted key, actual ok=%v err=%v", ok, err)
+	}
+
+	snapshot.Release()
+	if _, _, err := snapshot.Get([]byte("key-0000")); err != ErrSnapshotReleased {
+		t.Fatalf("Get from the released snapshot expected err=%v, actual err=%v", ErrSnapshotReleased, err)
+	}
+	// the tables with the old versions are merged again.
+	putRange(t, tree, 0, 60)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, tree, 0, 60)
+
+	tree.mu.RLock()
+	tables := tree.version.levels[levelNum-1]
+	tree.mu.RUnlock()
+	it, err := newConcatIterator(tree.tableCache, tables)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.close()
+	records := 0
+	for ; it.hasNext(); records++ {
+		if _, err := it.next(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if records != 60 {
+		t.Fatalf("the versions of the released snapshot must be dropped, expected 60 records, actual %d", records)
+	}
+}
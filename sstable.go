@@ -7,368 +7,590 @@ import (
 	"io/ioutil"
 	"os"
 	"path"
+	"sort"
 	"strconv"
 )
 
 // @Author KHighness
-// @Update 2023-09-16
+// @Update 2026-10-16
 
 const (
-	// ssTableMetaFileName is SSTable meta data name, It contains the max SSTable number.
+	// ssTableMetaFileName is SSTable meta data name, It contains the tables of each level.
 	ssTableMetaFileName = "meta.db"
-	// ssTableDataFileName is SSTable data file name. It contains raw data.
-	ssTableDataFileName = "data.db"
-	// ssTableIndexFileName is SSTable index file name. It contains keys and positions to values in the data file.
-	ssTableIndexFileName = "index.db"
-	// ssTableSparseIndexFileName is SSTable sparse index file name.
-	ssTableSparseIndexFileName = "sparse.db"
-	// A flag to open file for new SSTable files: data, index and sparse index.
+	// ssTableFileName is SSTable file name. It contains data blocks, meta blocks,
+	// index block and footer.
+	ssTableFileName = "table.db"
+	// A flag to open file for new SSTable files.
 	newSsTableFlag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND
+	// ssTableMagic is the magic number at the end of SSTable file.
+	ssTableMagic = 0x6c736d7472656521
+	// ssTableFormatVersion is the version of SSTable file format. The blocks
+	// and the footer of version 2 are followed by checksums, the values of
+	// version 3 are stored with sequence numbers, the values of version 4 may
+	// be stored with expiration time.
+	ssTableFormatVersion = 4
+	// ssTableFooterSize is the size of SSTable footer in bytes.
+	ssTableFooterSize = 48 + checksumSize
+	// ssTableFooterSizeV1 is the size of SSTable footer of version 1 in bytes.
+	ssTableFooterSizeV1 = 48
+	// ssTableFooterTailSize is the size of the format version and the magic number.
+	ssTableFooterTailSize = 16
 )
 
-// createSsTable create a SSTable from the given memTable with the given prefix
+// ssTableOptions are the options of the created SSTable.
+type ssTableOptions struct {
+	// sparseKeyDistance is the max number of keys in a data block.
+	sparseKeyDistance int
+	// blockSize is the size of data block in bytes, the block is finished
+	// when its size reaches it.
+	blockSize int
+	// bloomBitsPerKey is the number of filter bits per key, 0 disables the filter.
+	bloomBitsPerKey int
+}
+
+// blockHandle points to the data block of SSTable.
+type blockHandle struct {
+	// key is the first key of the block.
+	key []byte
+	// offset is the offset of the block in the file.
+	offset int
+	// size is the size of the block without the checksum.
+	size int
+}
+
+// createSsTable create a SSTable from the given memTable with the given index
 // and in the given directory.
-func createSsTable(mt *memTable, dbDir string, index, sparseKeyDistance int) error {
-	prefix := strconv.Itoa(index) + "-"
-	writer, err := newSsTableWriter(dbDir, prefix, sparseKeyDistance)
+func createSsTable(mt *memTable, dbDir string, index int, options ssTableOptions) (_ *tableMeta, err error) {
+	writer, err := newSsTableWriter(dbDir, index, options)
 	if err != nil {
-		return fmt.Errorf("failed to create sstable writer: %w", err)
+		return nil, fmt.Errorf("failed to create sstable writer: %w", err)
 	}
+	// the partially written table is removed, so it is not left in dbDir.
+	defer func() {
+		if err != nil {
+			writer.abort()
+		}
+	}()
 
 	for it := mt.iterator(); it.hasNext(); {
-		key, value := it.next()
-		if err := writer.write(key, value); err != nil {
-			return fmt.Errorf("failed to create sstable writer: %w", err)
+		if err := writer.write(it.next()); err != nil {
+			return nil, fmt.Errorf("failed to create sstable writer: %w", err)
 		}
 	}
 
 	if err := writer.sync(); err != nil {
-		return fmt.Errorf("failed to sync sstable: %w", err)
+		return nil, fmt.Errorf("failed to sync sstable: %w", err)
 	}
 
 	if err := writer.close(); err != nil {
-		return fmt.Errorf("failed to close sstable: %w", err)
+		return nil, fmt.Errorf("failed to close sstable: %w", err)
 	}
 
-	return nil
+	// the directory entry of the table must be durable before the table is
+	// added to the MANIFEST.
+	if err := syncDir(dbDir); err != nil {
+		return nil, err
+	}
+
+	return writer.meta(index), nil
 }
 
-// searchInSsTable searches a value of the given key in all SSTables, by traversing
-// all tables in the directory.
-func searchInSsTables(dbDir string, maxIndex int, key []byte) ([]byte, bool, error) {
-	for index := maxIndex; index >= 0; index-- {
-		value, exists, err := searchInSsTable(dbDir, index, key)
-		if err != nil {
-			return nil, false, fmt.Errorf("failed to search in sstable with index %d: %w", index, err)
+// searchInSsTable searches a value of the given key in the specific SSTable,
+// see ssTableReader.find.
+func searchInSsTable(dbDir string, index int, key []byte, seq uint64) ([]byte, bool, error) {
+	e, exists, err := findInSsTable(dbDir, index, key, seq)
+	return e.value, exists, err
+}
+
+// findInSsTable searches the version of the given key in the specific SSTable,
+// see ssTableReader.find.
+func findInSsTable(dbDir string, index int, key []byte, seq uint64) (entry, bool, error) {
+	r, err := openSsTable(dbDir, index)
+	if err != nil {
+		return entry{}, false, err
+	}
+	defer r.close()
+
+	return r.find(key, seq)
+}
+
+// deleteSsTable deletes SsTable files, including the files of the legacy format.
+func deleteSsTable(dbDir string, prefixes ...string) error {
+	for _, prefix := range prefixes {
+		tablePath := path.Join(dbDir, prefix+ssTableFileName)
+		if err := os.Remove(tablePath); err == nil {
+			continue
+		} else if !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove table file %s: %w", tablePath, err)
 		}
 
-		if exists {
-			return value, exists, nil
+		if err := deleteLegacySsTable(dbDir, prefix); err != nil {
+			return err
 		}
 	}
 
-	return nil, false, nil
+	return nil
 }
 
-// searchInSsTable searches a value of the given key in the specific SSTable.
-func searchInSsTable(dbDir string, index int, key []byte) ([]byte, bool, error) {
-	prefix := strconv.Itoa(index) + "-"
+// ssTableReader reads SSTable. The data blocks are placed at the beginning
+// of the file and contain the records encoded one by one, so the data of the
+// legacy SSTable, whose data file is a single block, is read in the same way.
+type ssTableReader struct {
+	file *os.File
+	// checksummed is true if the blocks are followed by checksums.
+	checksummed bool
+	// sequenced is true if the values are stored with sequence numbers.
+	// The records of the older tables have sequence number 0.
+	sequenced bool
+	// dataSize is the total size of the data blocks.
+	dataSize int
+	blocks   []blockHandle
+	// filterOffset and filterSize point to the bloom filter block, which is
+	// read by readFilter only, so the lookups do not read it again.
+	filterOffset, filterSize int
+	// legacyFilterPath is the path of the filter file of the legacy SSTable.
+	legacyFilterPath string
+}
 
-	sparseIndexPath := path.Join(dbDir, prefix+ssTableSparseIndexFileName)
-	sparseIndexFile, err := os.OpenFile(sparseIndexPath, os.O_RDONLY, 0600)
+// openSsTable opens SSTable with the given index. The legacy SSTable is
+// opened if there is no SSTable file.
+func openSsTable(dbDir string, index int) (*ssTableReader, error) {
+	tablePath := path.Join(dbDir, strconv.Itoa(index)+"-"+ssTableFileName)
+	file, err := os.OpenFile(tablePath, os.O_RDONLY, 0600)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to open sparse index file: %w", err)
+		if os.IsNotExist(err) {
+			return openLegacySsTable(dbDir, index)
+		}
+		return nil, fmt.Errorf("failed to open table file %s: %w", tablePath, err)
 	}
 
-	from, to, ok, err := searchInSparseIndex(sparseIndexFile, key)
+	r := &ssTableReader{file: file}
+	if err := r.readFooter(); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("failed to read table file %s: %w", tablePath, err)
+	}
+
+	return r, nil
+}
+
+// readFooter reads the footer and the blocks it points to.
+//
+//	Footer format:
+//	[index block offset][index block size]
+//	[filter block offset][filter block size]
+//	[checksum of the above][format version][magic number]
+//
+// The footer of version 1 has no checksum.
+func (r *ssTableReader) readFooter() error {
+	info, err := r.file.Stat()
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in sparse index %s: %w", sparseIndexPath, err)
+		return fmt.Errorf("failed to stat: %w", err)
 	}
-	if !ok {
-		return nil, false, nil
+	fileSize := info.Size()
+	if fileSize < ssTableFooterSizeV1 {
+		return r.corruption(0, "file is too small to be sstable")
 	}
 
-	indexPath := path.Join(dbDir, prefix+ssTableIndexFileName)
-	indexFile, err := os.OpenFile(indexPath, os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to open index file: %w", err)
+	tail := make([]byte, ssTableFooterTailSize)
+	if _, err := r.file.ReadAt(tail, fileSize-ssTableFooterTailSize); err != nil {
+		return fmt.Errorf("failed to read footer: %w", err)
 	}
 
-	offset, ok, err := searchInIndex(indexFile, from, to, key)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in index file %s: %w", indexPath, err)
+	formatVersion, magic := decodeIntPair(tail)
+	if magic != ssTableMagic {
+		return r.corruption(fileSize-ssTableFooterTailSize, fmt.Sprintf("bad magic number %x", magic))
+	}
+	if formatVersion > ssTableFormatVersion {
+		return fmt.Errorf("unsupported format version %d", formatVersion)
 	}
 
-	dataPath := path.Join(dbDir, prefix+ssTableDataFileName)
-	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to open data file: %w", err)
+	footerSize := int64(ssTableFooterSizeV1)
+	if formatVersion >= 2 {
+		footerSize = ssTableFooterSize
+		r.checksummed = true
+	}
+	r.sequenced = formatVersion >= 3
+	if fileSize < footerSize {
+		return r.corruption(0, "file is too small to be sstable")
 	}
 
-	value, ok, err := searchInDataFile(dataFile, offset, key)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in data file %s: %w", dataPath, err)
+	footer := make([]byte, footerSize-ssTableFooterTailSize)
+	if _, err := r.file.ReadAt(footer, fileSize-footerSize); err != nil {
+		return fmt.Errorf("failed to read footer: %w", err)
+	}
+	if r.checksummed && !verifyChecksum(footer[32:], footer[:32]) {
+		return r.corruption(fileSize-footerSize, "footer checksum mismatch")
 	}
 
-	if err := sparseIndexFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close sparse index file: %w", err)
+	indexOffset, indexSize := decodeIntPair(footer[0:])
+	filterOffset, filterSize := decodeIntPair(footer[16:])
+	for _, handle := range [][2]int{{indexOffset, indexSize}, {filterOffset, filterSize}} {
+		if handle[0] < 0 || handle[1] < 0 || int64(handle[0]+handle[1]) > fileSize-footerSize {
+			return r.corruption(fileSize-footerSize, "block handle is out of file")
+		}
 	}
 
-	if err := indexFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close index file: %w", err)
+	index, err := r.readBlock(indexOffset, indexSize)
+	if err != nil {
+		return fmt.Errorf("failed to read index block: %w", err)
 	}
 
-	if err := dataFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close data file: %w", err)
+	r.blocks = make([]blockHandle, 0, len(index))
+	for _, e := range index {
+		offset, size := decodeIntPair(e.value)
+		r.blocks = append(r.blocks, blockHandle{key: e.key, offset: offset, size: size})
 	}
 
-	return value, ok, nil
-}
+	r.filterOffset, r.filterSize = filterOffset, filterSize
 
-// searchInDataFile searches a value by the key in the data file from the given offset,
-// The offset must always point to the beginning of the record.
-func searchInDataFile(r io.ReadSeeker, offset int, searchKey []byte) ([]byte, bool, error) {
-	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, false, fmt.Errorf("failed to seek: %w", err)
+	// the meta blocks follow the data blocks.
+	r.dataSize = indexOffset
+	if filterSize > 0 {
+		r.dataSize = filterOffset
 	}
 
-	for {
-		key, value, err := decode(r)
-		if err != nil {
-			if err == io.EOF {
-				return nil, false, nil
-			}
-			return nil, false, fmt.Errorf("failed to read: %w", err)
-		}
+	return nil
+}
 
-		if bytes.Equal(key, searchKey) {
-			return value, true, nil
+// readFilter reads the bloom filter of the table keys. Returns nil if the
+// table has no filter.
+func (r *ssTableReader) readFilter() (bloomFilter, error) {
+	if r.legacyFilterPath != "" {
+		filter, err := ioutil.ReadFile(r.legacyFilterPath)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to read filter file %s: %w", r.legacyFilterPath, err)
 		}
+		return filter, nil
 	}
-}
 
-// searchInIndex searches key in the index file in specified range.
-func searchInIndex(r io.ReadSeeker, from, to int, searchKey []byte) (int, bool, error) {
-	if _, err := r.Seek(int64(from), io.SeekStart); err != nil {
-		return 0, false, fmt.Errorf("failed to seek: %w", err)
+	if r.filterSize == 0 {
+		return nil, nil
 	}
+	filter, err := r.readRawBlock(r.filterOffset, r.filterSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read filter block: %w", err)
+	}
+	return filter, nil
+}
 
-	for {
-		key, value, err := decode(r)
-		if err != nil {
-			if err == io.EOF {
-				return 0, false, nil
-			}
-			return 0, false, fmt.Errorf("failed to read: %w", err)
-		}
-		offset := decodeInt(value)
-
-		if bytes.Equal(key, searchKey) {
-			return offset, true, nil
-		}
+// corruption returns CorruptionError of the table file at the given offset.
+func (r *ssTableReader) corruption(offset int64, reason string) error {
+	return &CorruptionError{Path: r.file.Name(), Offset: offset, Reason: reason}
+}
 
-		if to > from {
-			current, err := r.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return 0, false, fmt.Errorf("failed to seek: %w", err)
-			}
+// readRawBlock reads the block at the given offset and verifies its checksum.
+func (r *ssTableReader) readRawBlock(offset, size int) ([]byte, error) {
+	readSize := size
+	if r.checksummed {
+		readSize += checksumSize
+	}
 
-			if current >= int64(to) {
-				return 0, false, nil
-			}
+	buf := make([]byte, readSize)
+	if _, err := r.file.ReadAt(buf, int64(offset)); err != nil {
+		if err == io.EOF {
+			return nil, r.corruption(int64(offset), "block is out of file")
 		}
+		return nil, fmt.Errorf("failed to read block at %d: %w", offset, err)
 	}
+
+	if r.checksummed && !verifyChecksum(buf[size:], buf[:size]) {
+		return nil, r.corruption(int64(offset), "block checksum mismatch")
+	}
+	return buf[:size], nil
 }
 
-// searchInSparseIndex searches a range between which the key is located.
-func searchInSparseIndex(r io.Reader, searchKey []byte) (int, int, bool, error) {
-	from := -1
+// readBlock reads and decodes the records of the block at the given offset.
+func (r *ssTableReader) readBlock(offset, size int) ([]entry, error) {
+	buf, err := r.readRawBlock(offset, size)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]entry, 0)
+	br := bytes.NewReader(buf)
 	for {
-		key, value, err := decode(r)
+		key, value, err := decode(br)
 		if err != nil {
 			if err == io.EOF {
-				return from, 0, from != -1, nil
-			}
-			return 0, 0, false, fmt.Errorf("failed to read: %w", err)
-		}
-
-		offset := decodeInt(value)
-		cmp := bytes.Compare(key, searchKey)
-		if cmp == 0 {
-			return offset, offset, true, nil
-		} else if cmp < 0 {
-			from = offset
-		} else {
-			if from == -1 {
-				return 0, 0, false, nil
-			} else {
-				return from, offset, true, nil
+				return entries, nil
 			}
+			return nil, r.corruption(int64(offset), fmt.Sprintf("failed to decode block: %s", err))
 		}
+		entries = append(entries, entry{key: key, value: value})
 	}
 }
 
-// renameSsTable rename SSTable files: data, index and sparse index files.
-func renameSsTable(dbDir string, oldPrefix, newPrefix string) error {
-	if err := os.Rename(path.Join(dbDir, oldPrefix+ssTableDataFileName), path.Join(dbDir, newPrefix+ssTableDataFileName)); err != nil {
-		return fmt.Errorf("failed to rename data file: %w", err)
-	}
-
-	if err := os.Rename(path.Join(dbDir, oldPrefix+ssTableIndexFileName), path.Join(dbDir, newPrefix+ssTableIndexFileName)); err != nil {
-		return fmt.Errorf("failed to rename index file: %w", err)
+// readDataBlock reads and decodes the data block with the given index.
+func (r *ssTableReader) readDataBlock(blockIndex int) ([]entry, error) {
+	offset := r.blocks[blockIndex].offset
+	entries, err := r.readBlock(offset, r.blocks[blockIndex].size)
+	if err != nil || !r.sequenced {
+		return entries, err
 	}
 
-	if err := os.Rename(path.Join(dbDir, oldPrefix+ssTableSparseIndexFileName), path.Join(dbDir, newPrefix+ssTableSparseIndexFileName)); err != nil {
-		return fmt.Errorf("failed to rename sparse index file: %w", err)
+	for i := range entries {
+		key := entries[i].key
+		entries[i], err = decodeInternalValue(entries[i].value)
+		if err != nil {
+			return nil, r.corruption(int64(offset), fmt.Sprintf("failed to decode block: %s", err))
+		}
+		entries[i].key = key
 	}
+	return entries, nil
+}
 
-	return nil
+// findBlock returns the index of the last block whose first key is less or
+// equal to the given key, or -1 if the key is less than all keys.
+func (r *ssTableReader) findBlock(key []byte) int {
+	return sort.Search(len(r.blocks), func(i int) bool {
+		return bytes.Compare(r.blocks[i].key, key) > 0
+	}) - 1
 }
 
-// deleteSsTable deletes SsTable: data, index and sparse index files.
-func deleteSsTable(dbDir string, prefixes ...string) error {
-	for _, prefix := range prefixes {
-		dataPath := path.Join(dbDir, prefix+ssTableDataFileName)
-		if err := os.Remove(dataPath); err != nil {
-			return fmt.Errorf("failed to remove data file %s: %w", dataPath, err)
-		}
+// find searches the newest version of the given key whose sequence number is
+// not greater than the given one. All versions of the key are in the same block.
+// The bloom filter is not checked, see tableMeta.mayContain.
+func (r *ssTableReader) find(key []byte, seq uint64) (entry, bool, error) {
+	blockIndex := r.findBlock(key)
+	if blockIndex < 0 {
+		return entry{}, false, nil
+	}
 
-		indexPath := path.Join(dbDir, prefix+ssTableIndexFileName)
-		if err := os.Remove(indexPath); err != nil {
-			return fmt.Errorf("failed to remove index file %s: %w", indexPath, err)
-		}
+	entries, err := r.readDataBlock(blockIndex)
+	if err != nil {
+		return entry{}, false, err
+	}
 
-		sparseIndexPath := path.Join(dbDir, prefix+ssTableSparseIndexFileName)
-		if err := os.Remove(sparseIndexPath); err != nil {
-			return fmt.Errorf("failed to remove sparse index file %s: %w", sparseIndexPath, err)
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].key, key) >= 0
+	})
+	for ; i < len(entries) && bytes.Equal(entries[i].key, key); i++ {
+		if entries[i].seq <= seq {
+			return entries[i], true, nil
 		}
 	}
 
+	return entry{}, false, nil
+}
+
+// close closes the table file.
+func (r *ssTableReader) close() error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close table file: %w", err)
+	}
 	return nil
 }
 
-// ssTableWriter is a simple abstraction over SSTable, but only for the writing purposes.
+// ssTableWriter writes SSTable file.
+//
+//	File format:
+//	[data block][checksum][data block][checksum]...
+//	[filter block][checksum]
+//	[index block][checksum]
+//	[footer]
+//
+// The data blocks contain the encoded records, whose values are encoded with
+// the sequence numbers by encodeInternalValue. The versions of the same key
+// go from the newest to the oldest and are never split between the blocks.
+// The index block contains the
+// first key of each data block with its offset and size, the optional filter
+// block contains the bloom filter of all keys. The sizes of the blocks do not
+// include CRC-32C checksums following them.
 type ssTableWriter struct {
-	dataFile        *os.File
-	indexFile       *os.File
-	sparseIndexFile *os.File
-
-	sparseKeyDistance int
-
-	keyNum, dataPos, indexPos int
+	file *os.File
+
+	options ssTableOptions
+
+	// block is the current data block, blockKey is its first key.
+	block       bytes.Buffer
+	blockKey    []byte
+	blockKeyNum int
+	index       bytes.Buffer
+	// offset is the size of the written blocks.
+	offset int
+
+	keyHashes []uint32
+	filter    bloomFilter
+
+	// dataPos is the size of all written records.
+	keyNum, dataPos int
+	// lastSeq is the sequence number of the last record, garbageSeq and
+	// minExpiresAt are described by tableMeta.
+	lastSeq, garbageSeq uint64
+	minExpiresAt        int64
+
+	smallest, largest []byte
+	finished          bool
 }
 
 // newSsTableWriter creates a new instance of SSTable writer.
-func newSsTableWriter(dbDir, prefix string, sparseKeyDistance int) (*ssTableWriter, error) {
-	dataPath := path.Join(dbDir, prefix+ssTableDataFileName)
-	dataFile, err := os.OpenFile(dataPath, newSsTableFlag, 0600)
+func newSsTableWriter(dbDir string, index int, options ssTableOptions) (*ssTableWriter, error) {
+	tablePath := path.Join(dbDir, strconv.Itoa(index)+"-"+ssTableFileName)
+	file, err := os.OpenFile(tablePath, newSsTableFlag, 0600)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open data file %s: %w", dataPath, err)
-	}
-
-	indexPath := path.Join(dbDir, prefix+ssTableIndexFileName)
-	indexFile, err := os.OpenFile(indexPath, newSsTableFlag, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open index file %s: %w", indexPath, err)
-	}
-
-	sparseIndexPath := path.Join(dbDir, prefix+ssTableSparseIndexFileName)
-	sparseIndexFile, err := os.OpenFile(sparseIndexPath, newSsTableFlag, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open spare index file %s: %w", sparseIndexPath, err)
+		return nil, fmt.Errorf("failed to open table file %s: %w", tablePath, err)
 	}
 
 	return &ssTableWriter{
-		dataFile:          dataFile,
-		indexFile:         indexFile,
-		sparseIndexFile:   sparseIndexFile,
-		sparseKeyDistance: sparseKeyDistance,
-		keyNum:            0,
-		dataPos:           0,
-		indexPos:          0,
+		file:    file,
+		options: options,
 	}, nil
 }
 
-// write writes key and value into the SSTable: data, index and sparse index file.
-func (w *ssTableWriter) write(key, value []byte) error {
-	dataBytes, err := encode(key, value, w.dataFile)
-	if err != nil {
-		return fmt.Errorf("failed to write to the data file: %w", err)
+// write writes the record into the current data block. The full block is
+// flushed before the next key, so the versions of the key stay together.
+func (w *ssTableWriter) write(e entry) error {
+	key := e.key
+	newKey := w.keyNum == 0 || !bytes.Equal(key, w.largest)
+	if newKey && (w.block.Len() >= w.options.blockSize || w.blockKeyNum >= w.options.sparseKeyDistance) {
+		if err := w.flushBlock(); err != nil {
+			return err
+		}
+	}
+	if w.block.Len() == 0 {
+		w.blockKey = key
 	}
 
-	indexBytes, err := encodeKeyOffset(key, w.dataPos, w.indexFile)
+	n, err := encode(key, encodeInternalValue(e), &w.block)
 	if err != nil {
-		return fmt.Errorf("failed to write to the index file: %w", err)
+		return fmt.Errorf("failed to encode record: %w", err)
 	}
 
-	if w.keyNum%w.sparseKeyDistance == 0 {
-		if _, err := encodeKeyOffset(key, w.indexPos, w.sparseIndexFile); err != nil {
-			return fmt.Errorf("failed to write to the file: %w", err)
-		}
+	if w.options.bloomBitsPerKey > 0 && newKey {
+		w.keyHashes = append(w.keyHashes, bloomHash(key))
+	}
+
+	// the tombstone is needed while it is visible to the snapshots, the older
+	// version while the newer one is invisible to them.
+	if e.value == nil && e.seq > w.garbageSeq {
+		w.garbageSeq = e.seq
+	}
+	if !newKey && w.lastSeq > w.garbageSeq {
+		w.garbageSeq = w.lastSeq
+	}
+	w.lastSeq = e.seq
+	if e.expiresAt != 0 && (w.minExpiresAt == 0 || e.expiresAt < w.minExpiresAt) {
+		w.minExpiresAt = e.expiresAt
+	}
+
+	if w.keyNum == 0 {
+		w.smallest = key
 	}
+	w.largest = key
 
-	w.dataPos += dataBytes
-	w.indexPos += indexBytes
+	w.dataPos += n
 	w.keyNum++
+	w.blockKeyNum++
 
 	return nil
 }
 
-// sync commits all written contents to the stable storage.
-func (w *ssTableWriter) sync() error {
-	if err := w.dataFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync data file: %w", err)
+// flushBlock writes the current data block into the file and adds it to the index.
+func (w *ssTableWriter) flushBlock() error {
+	if w.block.Len() == 0 {
+		return nil
 	}
 
-	if err := w.indexFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync index file: %w", err)
+	if _, err := encode(w.blockKey, encodeIntPair(w.offset, w.block.Len()), &w.index); err != nil {
+		return fmt.Errorf("failed to encode index: %w", err)
 	}
 
-	if err := w.sparseIndexFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync sparse index file: %w", err)
+	if err := w.writeBlock(w.block.Bytes()); err != nil {
+		return fmt.Errorf("failed to write data block: %w", err)
 	}
 
+	w.block.Reset()
+	w.blockKey, w.blockKeyNum = nil, 0
 	return nil
 }
 
-// close closes all associated files with the SSTable.
-func (w *ssTableWriter) close() error {
-	if err := w.dataFile.Close(); err != nil {
-		return fmt.Errorf("failed to cloase data file: %w", err)
+// finish writes the last data block, the meta blocks and the footer.
+func (w *ssTableWriter) finish() error {
+	if err := w.flushBlock(); err != nil {
+		return err
+	}
+
+	filterOffset, filterSize := w.offset, 0
+	if w.options.bloomBitsPerKey > 0 {
+		w.filter = newBloomFilter(w.keyHashes, w.options.bloomBitsPerKey)
+		w.keyHashes = nil
+		if err := w.writeBlock(w.filter); err != nil {
+			return fmt.Errorf("failed to write filter block: %w", err)
+		}
+		filterSize = len(w.filter)
 	}
 
-	if err := w.indexFile.Close(); err != nil {
-		return fmt.Errorf("failed to close index file: %w", err)
+	indexOffset, indexSize := w.offset, w.index.Len()
+	if err := w.writeBlock(w.index.Bytes()); err != nil {
+		return fmt.Errorf("failed to write index block: %w", err)
 	}
 
-	if err := w.sparseIndexFile.Close(); err != nil {
-		return fmt.Errorf("failed to close sparse index file: %w", err)
+	var footer bytes.Buffer
+	footer.Write(encodeIntPair(indexOffset, indexSize))
+	footer.Write(encodeIntPair(filterOffset, filterSize))
+	footer.Write(encodeChecksum(footer.Bytes()))
+	footer.Write(encodeIntPair(ssTableFormatVersion, ssTableMagic))
+	if _, err := w.file.Write(footer.Bytes()); err != nil {
+		return fmt.Errorf("failed to write footer: %w", err)
 	}
 
+	w.finished = true
 	return nil
 }
 
-// updateSsTable updates the current max SSTable number.
-func updateSsTableMeta(dbDir string, num, max int) error {
-	filePath := path.Join(dbDir, ssTableMetaFileName)
-	if err := ioutil.WriteFile(filePath, encodeIntPair(num, max), 0600); err != nil {
-		return fmt.Errorf("failed to write %s: %w", filePath, err)
+// writeBlock writes the block followed by its checksum.
+func (w *ssTableWriter) writeBlock(block []byte) error {
+	if _, err := w.file.Write(block); err != nil {
+		return err
+	}
+	if _, err := w.file.Write(encodeChecksum(block)); err != nil {
+		return err
 	}
 
+	w.offset += len(block) + checksumSize
 	return nil
 }
 
-// readSsTableMeta reads and returns the number of SSTable and the max index.
-func readSsTableMeta(dbDir string) (int, int, error) {
-	filePath := path.Join(dbDir, ssTableMetaFileName)
-	data, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return 0, -1, nil
+// meta returns the description of the written table with the given index.
+func (w *ssTableWriter) meta(index int) *tableMeta {
+	return &tableMeta{
+		id:           index,
+		size:         w.dataPos,
+		smallest:     w.smallest,
+		largest:      w.largest,
+		garbageSeq:   w.garbageSeq,
+		minExpiresAt: w.minExpiresAt,
+		filter:       w.filter,
+	}
+}
+
+// sync finishes the table and commits all written contents to the stable storage.
+func (w *ssTableWriter) sync() error {
+	if !w.finished {
+		if err := w.finish(); err != nil {
+			return fmt.Errorf("failed to finish table: %w", err)
 		}
-		return 0, 0, fmt.Errorf("failed to read file %s: %w", filePath, err)
 	}
 
-	num, max := decodeIntPair(data)
-	return num, max, nil
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync table file: %w", err)
+	}
+
+	return nil
+}
+
+// close closes the table file.
+func (w *ssTableWriter) close() error {
+	if err := w.file.Close(); err != nil {
+		return fmt.Errorf("failed to close table file: %w", err)
+	}
+
+	return nil
+}
+
+// abort closes and removes the table file, it is called if the table cannot
+// be written completely.
+func (w *ssTableWriter) abort() {
+	_ = w.file.Close()
+	_ = os.Remove(w.file.Name())
 }
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
+	"strconv"
 	"testing"
 )
 
 // @Author KHighness
-// @Update 2023-09-30
+// @Update 2026-10-16
 
 func TestSearchInSsTable(t *testing.T) {
 	dbDir, close, err := prepareSsTable(prepareMemTable(), 0, 3)
@@ -33,7 +35,7 @@ func TestSearchInSsTable(t *testing.T) {
 	}
 
 	for _, c := range cases {
-		value, ok, err := searchInSsTable(dbDir, c.maxIndex, c.key)
+		value, ok, err := searchInSsTable(dbDir, c.maxIndex, c.key, maxSequence)
 		if c.hasErr && err == nil {
 			t.Fatalf("searchInSsTable expected hasErr=true, actual err=nil")
 		}
@@ -48,16 +50,168 @@ func TestSearchInSsTable(t *testing.T) {
 	}
 }
 
+func TestSsTableCursor(t *testing.T) {
+	dbDir, close, err := prepareSsTable(prepareMemTable(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close()
+
+	c, err := newSsTableCursor(newTableCache(dbDir, 0), 0, maxSequence)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.close()
+
+	cases := []struct {
+		move     func() error
+		expected []byte
+	}{
+		{c.first, []byte("a")},
+		{c.next, []byte("b")},
+		{c.last, []byte("g")},
+		{c.prev, []byte("f")},
+		{c.prev, []byte("e")},
+		{c.prev, []byte("d")},
+		{c.prev, []byte("c")},
+		{func() error { return c.seek([]byte("0")) }, []byte("a")},
+		{func() error { return c.seek([]byte("cc")) }, []byte("d")},
+		{func() error { return c.seek([]byte("z")) }, nil},
+		{func() error { return c.seekForPrev([]byte("0")) }, nil},
+		{func() error { return c.seekForPrev([]byte("cc")) }, []byte("c")},
+		{func() error { return c.seekForPrev([]byte("z")) }, []byte("g")},
+	}
+
+	for i, cs := range cases {
+		if err := cs.move(); err != nil {
+			t.Fatalf("case %d: move error: %s", i, err)
+		}
+		if cs.expected == nil {
+			if c.valid() {
+				t.Fatalf("case %d: expected invalid cursor, actual key=%s", i, c.key())
+			}
+			continue
+		}
+		if !c.valid() || !bytes.Equal(cs.expected, c.key()) {
+			t.Fatalf("case %d: expected key=%s, actual valid=%v", i, cs.expected, c.valid())
+		}
+	}
+}
+
+func TestOpenSsTable(t *testing.T) {
+	dbDir, close, err := prepareSsTable(prepareMemTable(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close()
+
+	r, err := openSsTable(dbDir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filter, err := r.readFilter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.blocks) != 3 || filter == nil {
+		t.Fatalf("openSsTable expected 3 blocks and filter, actual blocks=%d filter=%v", len(r.blocks), filter != nil)
+	}
+	if err := r.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tablePath := path.Join(dbDir, "0-"+ssTableFileName)
+	data, err := ioutil.ReadFile(tablePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1]++
+	if err := ioutil.WriteFile(tablePath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openSsTable(dbDir, 0); err == nil {
+		t.Fatalf("openSsTable expected error for bad magic number")
+	}
+}
+
+func TestSsTableWriter_Abort(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	writer, err := newSsTableWriter(dbDir, 0, ssTableOptions{sparseKeyDistance: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.write(entry{key: []byte("a"), value: []byte("va"), seq: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the partially written table must not be left in dbDir.
+	writer.abort()
+	if _, err := os.Stat(path.Join(dbDir, "0-"+ssTableFileName)); !os.IsNotExist(err) {
+		t.Fatalf("the aborted table must be removed, actual err=%v", err)
+	}
+}
+
+func TestLegacySsTable(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	for index := 0; index < 2; index++ {
+		mt := newMemTable()
+		for i := 0; i < 10; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i*2+index))
+			mt.put(key, key, uint64(i+1))
+		}
+		if err := writeLegacySsTable(mt, dbDir, index, 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(dbDir, ssTableMetaFileName), encodeIntPair(2, 1), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	value, ok, err := searchInSsTable(dbDir, 1, []byte("key-0007"), maxSequence)
+	if err != nil || !ok || string(value) != "key-0007" {
+		t.Fatalf("searchInSsTable expected value=key-0007, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+
+	tree, err := Open(dbDir, CompactionScheduler(NewThresholdScheduler(0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 20)
+
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, tree, 0, 20)
+	for index := 0; index < 2; index++ {
+		for _, name := range []string{legacyDataFileName, legacyIndexFileName, legacySparseIndexFileName} {
+			if _, err := os.Stat(path.Join(dbDir, strconv.Itoa(index)+"-"+name)); !os.IsNotExist(err) {
+				t.Fatalf("compaction expected legacy file %d-%s to be removed, actual err=%v", index, name, err)
+			}
+		}
+	}
+}
+
 func prepareMemTable() *memTable {
 	mt := newMemTable()
 
-	mt.put([]byte("a"), []byte("va"))
-	mt.put([]byte("b"), []byte("vb"))
-	mt.put([]byte("c"), []byte("vc"))
-	mt.put([]byte("d"), []byte("vd"))
-	mt.put([]byte("e"), []byte("ve"))
-	mt.put([]byte("f"), []byte("vf"))
-	mt.put([]byte("g"), []byte("vg"))
+	mt.put([]byte("a"), []byte("va"), 1)
+	mt.put([]byte("b"), []byte("vb"), 2)
+	mt.put([]byte("c"), []byte("vc"), 3)
+	mt.put([]byte("d"), []byte("vd"), 4)
+	mt.put([]byte("e"), []byte("ve"), 5)
+	mt.put([]byte("f"), []byte("vf"), 6)
+	mt.put([]byte("g"), []byte("vg"), 7)
 
 	return mt
 }
@@ -68,7 +222,11 @@ func prepareSsTable(mt *memTable, index, sparseKeyInstance int) (string, func(),
 		return "", nil, err
 	}
 
-	if err = createSsTable(mt, dbDir, index, sparseKeyInstance); err != nil {
+	if _, err = createSsTable(mt, dbDir, index, ssTableOptions{
+		sparseKeyDistance: sparseKeyInstance,
+		blockSize:         defaultBlockSize,
+		bloomBitsPerKey:   defaultBloomBitsPerKey,
+	}); err != nil {
 		return "", nil, err
 	}
 
@@ -78,3 +236,37 @@ func prepareSsTable(mt *memTable, index, sparseKeyInstance int) (string, func(),
 		}
 	}, nil
 }
+
+// writeLegacySsTable writes the legacy SSTable of three files, whose sparse index
+// contains the offsets in the index file.
+func writeLegacySsTable(mt *memTable, dbDir string, index, sparseKeyDistance int) error {
+	prefix := strconv.Itoa(index) + "-"
+	var data, indexData, sparseIndexData bytes.Buffer
+	for it, keyNum := mt.iterator(), 0; it.hasNext(); keyNum++ {
+		e := it.next()
+		key, value := e.key, e.value
+		if keyNum%sparseKeyDistance == 0 {
+			if _, err := encodeKeyOffset(key, indexData.Len(), &sparseIndexData); err != nil {
+				return err
+			}
+		}
+		if _, err := encodeKeyOffset(key, data.Len(), &indexData); err != nil {
+			return err
+		}
+		if _, err := encode(key, value, &data); err != nil {
+			return err
+		}
+	}
+
+	for name, buf := range map[string]*bytes.Buffer{
+		legacyDataFileName:        &data,
+		legacyIndexFileName:       &indexData,
+		legacySparseIndexFileName: &sparseIndexData,
+	} {
+		if err := ioutil.WriteFile(path.Join(dbDir, prefix+name), buf.Bytes(), 0600); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,373 @@
+package lsmtree
+
+import (
+	"bytes"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// bucketLow and bucketHigh are the bounds of the table size relative to
+	// the average size of the bucket, the table within the bounds is placed
+	// into the bucket by SizeTieredStrategy.
+	bucketLow  = 0.5
+	bucketHigh = 1.5
+)
+
+// Strategy is the compaction strategy which decides the layout of SSTables.
+type Strategy int
+
+const (
+	// LeveledStrategy is default strategy. The flushed tables are placed into
+	// level 0 and merged into the deeper levels of non-overlapping tables, each
+	// level is levelSizeMultiplier times larger than the previous one. It keeps
+	// the read and space amplification low at the cost of rewriting the data
+	// on every level. The tables of the last level are rewritten in place to
+	// drop the tombstones and the shadowed versions no snapshot needs, and the
+	// tables with the expired values or the records deleted by the range
+	// tombstones are compacted to drop them.
+	LeveledStrategy Strategy = iota
+	// SizeTieredStrategy keeps all tables in level 0. The neighbour tables of
+	// similar size are grouped into buckets, and ssTableNumberThreshold tables
+	// of the bucket are merged into a single table at a time. It rewrites the
+	// data less often, so it suits the write-heavy load, but the reads have to
+	// check more tables.
+	SizeTieredStrategy
+)
+
+// compactionStrategy picks the tables merged by the compaction.
+type compactionStrategy interface {
+	// score returns the compaction score of the version. The compaction is
+	// required when it reaches 1, the score is 0 if there is nothing to compact.
+	score(t *LSMTree, v *version) float64
+	// pick returns the compaction with the highest priority, or nil if there
+	// is nothing to compact.
+	pick(t *LSMTree, v *version) *compaction
+	// pickRange returns the compaction of the tables containing keys in range
+	// [start, end], or nil if the range is fully compacted.
+	pickRange(t *LSMTree, v *version, start, end []byte) *compaction
+}
+
+// compactionStrategy returns the implementation of the configured strategy.
+func (t *LSMTree) compactionStrategy() compactionStrategy {
+	if t.strategy == SizeTieredStrategy {
+		return sizeTieredStrategy{}
+	}
+	return leveledStrategy{}
+}
+
+// leveledStrategy implements LeveledStrategy.
+type leveledStrategy struct{}
+
+// levelScore returns the compaction score of the level.
+func (s leveledStrategy) levelScore(t *LSMTree, v *version, level int) float64 {
+	if level == levelNum-1 {
+		return 0
+	} else if level == 0 {
+		return float64(len(v.levels[0])) / float64(t.ssTableNumberThreshold)
+	}
+	return float64(v.levelSize(level)) / float64(t.levelMaxSize(level))
+}
+
+// pickLevel returns the level with the highest compaction score and the score.
+func (s leveledStrategy) pickLevel(t *LSMTree, v *version) (int, float64) {
+	best, bestScore := -1, 0.0
+	for level := 0; level < levelNum-1; level++ {
+		if score := s.levelScore(t, v, level); score > bestScore {
+			best, bestScore = level, score
+		}
+	}
+	return best, bestScore
+}
+
+// newCompaction creates the compaction of the input table of the level.
+func (s leveledStrategy) newCompaction(t *LSMTree, v *version, level int, input *tableMeta) *compaction {
+	t.compactPointers[level] = input.largest
+	return &compaction{
+		level:         level,
+		inputs:        []*tableMeta{input},
+		outputLevel:   level + 1,
+		overlaps:      v.overlappingTables(level+1, input.smallest, input.largest),
+		maxOutputSize: t.ssTableTargetSize,
+	}
+}
+
+// staleTable returns the level and the table containing keys in range
+// [start, end] which has the expired values, or the last level table whose
+// tombstones or shadowed versions are not needed by any snapshot. Returns
+// nil if there is none.
+func (s leveledStrategy) staleTable(t *LSMTree, v *version, start, end []byte) (int, *tableMeta) {
+	now, oldestSnapshot := time.Now().UnixNano(), t.oldestSnapshot()
+	for level := 0; level < levelNum; level++ {
+		for _, m := range v.overlappingTables(level, start, end) {
+			if m.hasExpired(now) || (level == levelNum-1 && m.hasGarbage(oldestSnapshot)) {
+				return level, m
+			}
+		}
+	}
+	return -1, nil
+}
+
+// staleRangeTombstone returns the range tombstone containing keys in range
+// [start, end] which no snapshot needs, and the level and the table containing
+// the records deleted by it. The table is nil if no table contains such records,
+// so the tombstone is just dropped. Returns nil if there is no such tombstone.
+func (s leveledStrategy) staleRangeTombstone(t *LSMTree, v *version, start, end []byte) (*rangeTombstone, int, *tableMeta) {
+	t.mu.RLock()
+	snapshots := t.snapshots.clone()
+	t.mu.RUnlock()
+
+	for i := range v.rangeDels {
+		r := &v.rangeDels[i]
+		if snapshots.visible(0, r.seq) ||
+			(start != nil && bytes.Compare(start, r.end) >= 0) || (end != nil && bytes.Compare(r.start, end) > 0) {
+			continue
+		}
+
+		for level := 0; level < levelNum; level++ {
+			for _, m := range v.overlappingTables(level, r.start, r.end) {
+				// the table which fails to be searched is compacted, so the
+				// compaction reports the error.
+				if covers, err := t.coversTable(m, *r); err != nil || covers {
+					return r, level, m
+				}
+			}
+		}
+		return r, -1, nil
+	}
+	return nil, -1, nil
+}
+
+// pickStale returns the compaction of the stale table or of the range tombstone
+// which no snapshot needs, or nil if there is none.
+func (s leveledStrategy) pickStale(t *LSMTree, v *version, start, end []byte) *compaction {
+	if level, m := s.staleTable(t, v, start, end); m != nil {
+		return s.newStaleCompaction(t, v, level, m)
+	}
+
+	r, level, m := s.staleRangeTombstone(t, v, start, end)
+	if r == nil {
+		return nil
+	} else if m != nil {
+		return s.newStaleCompaction(t, v, level, m)
+	}
+	return &compaction{
+		level:             levelNum - 1,
+		outputLevel:       levelNum - 1,
+		obsoleteRangeDels: rangeTombstones{*r},
+	}
+}
+
+// newStaleCompaction creates the compaction of the stale table of the level.
+// The table of the last level is rewritten in place, the tables of the upper
+// levels are merged into the next level, which replaces the expired values
+// with the tombstones.
+func (s leveledStrategy) newStaleCompaction(t *LSMTree, v *version, level int, input *tableMeta) *compaction {
+	if level < levelNum-1 {
+		// level 0 tables overlap each other, so the oldest one goes first.
+		if level == 0 {
+			input = v.levels[0][0]
+		}
+		return s.newCompaction(t, v, level, input)
+	}
+
+	return &compaction{
+		level:         level,
+		inputs:        []*tableMeta{input},
+		outputLevel:   level,
+		maxOutputSize: t.ssTableTargetSize,
+	}
+}
+
+// score implements compactionStrategy. The stale table and the range tombstone
+// which no snapshot needs require the compaction too, the tables covered by the
+// tombstone are searched only when the compaction is picked.
+func (s leveledStrategy) score(t *LSMTree, v *version) float64 {
+	_, score := s.pickLevel(t, v)
+	if score >= 1 {
+		return score
+	}
+	if _, m := s.staleTable(t, v, nil, nil); m != nil {
+		return 1
+	}
+	if s.hasStaleRangeTombstone(t, v) {
+		return 1
+	}
+	return score
+}
+
+// hasStaleRangeTombstone returns true if the version has the range tombstone
+// which no snapshot needs. Unlike staleRangeTombstone, the tables are not
+// searched, so the score is computed from the metadata only.
+func (s leveledStrategy) hasStaleRangeTombstone(t *LSMTree, v *version) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, r := range v.rangeDels {
+		if !t.snapshots.visible(0, r.seq) {
+			return true
+		}
+	}
+	return false
+}
+
+// pick implements compactionStrategy. The level with the highest score is
+// compacted: level 0 tables overlap each other, so the oldest one is compacted
+// first, the deeper levels are compacted in the round-robin manner. If no level
+// requires the compaction, the stale table or the range tombstone which no
+// snapshot needs is compacted.
+func (s leveledStrategy) pick(t *LSMTree, v *version) *compaction {
+	level, score := s.pickLevel(t, v)
+	if score < 1 {
+		if c := s.pickStale(t, v, nil, nil); c != nil {
+			return c
+		}
+	}
+	if level < 0 {
+		return nil
+	}
+
+	input := v.levels[level][0]
+	if level > 0 {
+		for _, m := range v.levels[level] {
+			if bytes.Compare(m.smallest, t.compactPointers[level]) > 0 {
+				input = m
+				break
+			}
+		}
+	}
+
+	return s.newCompaction(t, v, level, input)
+}
+
+// pickRange implements compactionStrategy. The tables of the upper levels are
+// compacted first, so the keys are moved to the last level, then the stale
+// tables of the last level are rewritten and the range tombstones which no
+// snapshot needs are dropped.
+func (s leveledStrategy) pickRange(t *LSMTree, v *version, start, end []byte) *compaction {
+	for level := 0; level < levelNum-1; level++ {
+		tables := v.overlappingTables(level, start, end)
+		if len(tables) == 0 {
+			continue
+		}
+
+		// level 0 tables overlap each other, so they are always
+		// compacted starting from the oldest one.
+		input := tables[0]
+		if level == 0 {
+			input = v.levels[0][0]
+		}
+		return s.newCompaction(t, v, level, input)
+	}
+
+	return s.pickStale(t, v, start, end)
+}
+
+// sizeTieredStrategy implements SizeTieredStrategy. The tables left in the deeper
+// levels by LeveledStrategy are not compacted.
+type sizeTieredStrategy struct{}
+
+// mergeWidth returns the number of tables merged at a time.
+func (s sizeTieredStrategy) mergeWidth(t *LSMTree) int {
+	if t.ssTableNumberThreshold < 2 {
+		return 2
+	}
+	return t.ssTableNumberThreshold
+}
+
+// buckets splits level 0 into the buckets of neighbour tables of similar size.
+// Only the neighbour tables are merged, so the merged table takes their place
+// in level 0 and the newer tables still shadow it. The tables smaller than
+// MemTable size threshold are always considered similar.
+func (s sizeTieredStrategy) buckets(t *LSMTree, v *version) [][]*tableMeta {
+	buckets := make([][]*tableMeta, 0)
+	var bucket []*tableMeta
+	total := 0
+	for _, m := range v.levels[0] {
+		if len(bucket) > 0 {
+			avg := float64(total) / float64(len(bucket))
+			small := m.size <= t.memTableSizeThreshold && avg <= float64(t.memTableSizeThreshold)
+			similar := float64(m.size) >= avg*bucketLow && float64(m.size) <= avg*bucketHigh
+			if !small && !similar {
+				buckets = append(buckets, bucket)
+				bucket, total = nil, 0
+			}
+		}
+
+		bucket = append(bucket, m)
+		total += m.size
+	}
+	if len(bucket) > 0 {
+		buckets = append(buckets, bucket)
+	}
+
+	return buckets
+}
+
+// newCompaction creates the compaction merging the neighbour tables of level 0.
+func (s sizeTieredStrategy) newCompaction(inputs []*tableMeta) *compaction {
+	return &compaction{
+		level:       0,
+		inputs:      inputs,
+		outputLevel: 0,
+	}
+}
+
+// score implements compactionStrategy. It is the size of the largest bucket
+// relative to the merge width, the buckets of a single table are not counted.
+func (s sizeTieredStrategy) score(t *LSMTree, v *version) float64 {
+	best := 0
+	for _, bucket := range s.buckets(t, v) {
+		if len(bucket) > best {
+			best = len(bucket)
+		}
+	}
+
+	if best < 2 {
+		return 0
+	}
+	return float64(best) / float64(s.mergeWidth(t))
+}
+
+// pick implements compactionStrategy. The oldest tables of the oldest bucket
+// reaching the merge width are merged. If there is no such bucket, which is the
+// case of the idle compaction, the largest bucket is merged.
+func (s sizeTieredStrategy) pick(t *LSMTree, v *version) *compaction {
+	width := s.mergeWidth(t)
+
+	var largest []*tableMeta
+	for _, bucket := range s.buckets(t, v) {
+		if len(bucket) >= width {
+			return s.newCompaction(bucket[:width])
+		}
+		if len(bucket) > len(largest) {
+			largest = bucket
+		}
+	}
+
+	if len(largest) < 2 {
+		return nil
+	}
+	return s.newCompaction(largest)
+}
+
+// pickRange implements compactionStrategy. All the tables between the oldest
+// and the newest tables containing keys in range are merged into one.
+func (s sizeTieredStrategy) pickRange(t *LSMTree, v *version, start, end []byte) *compaction {
+	first, last := -1, -1
+	for i, m := range v.levels[0] {
+		if m.overlaps(start, end) {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+
+	if first == last {
+		return nil
+	}
+	return s.newCompaction(v.levels[0][first : last+1])
+}
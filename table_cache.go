@@ -0,0 +1,159 @@
+package lsmtree
+
+import (
+	"container/list"
+	"sync"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// defaultTableCacheSize is default number of SSTables kept open by the table cache.
+const defaultTableCacheSize = 500
+
+// TableCacheSize sets the number of SSTables kept open for the lookups for LSMTree.
+// The non-positive size keeps no tables open between the lookups.
+func TableCacheSize(tableCacheSize int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.tableCacheSize = tableCacheSize
+	}
+}
+
+// tableCache keeps the readers of the recently searched SSTables open, so the
+// lookup does not read the footer and the index block of the table again.
+// The reader is closed once it is evicted and no lookup uses it.
+type tableCache struct {
+	dbDir    string
+	capacity int
+
+	mu sync.Mutex
+	// lru holds the cached tables from the most to the least recently used,
+	// tables maps the table ids to the elements of lru.
+	lru    *list.List
+	tables map[int]*list.Element
+}
+
+// cachedTable is the reader of SSTable shared by the lookups.
+type cachedTable struct {
+	id     int
+	reader *ssTableReader
+	// refs is the number of the lookups using the reader plus one while
+	// the reader is cached. It is guarded by tableCache.mu.
+	refs int
+}
+
+// newTableCache creates the cache of at most capacity readers of the tables in dbDir.
+func newTableCache(dbDir string, capacity int) *tableCache {
+	return &tableCache{
+		dbDir:    dbDir,
+		capacity: capacity,
+		lru:      list.New(),
+		tables:   make(map[int]*list.Element),
+	}
+}
+
+// find searches the version of the key in the table with the given id, see
+// ssTableReader.find. The bloom filter is not checked, see tableMeta.mayContain.
+func (c *tableCache) find(id int, key []byte, seq uint64) (entry, bool, error) {
+	table, err := c.acquire(id)
+	if err != nil {
+		return entry{}, false, err
+	}
+	defer c.release(table)
+
+	return table.reader.find(key, seq)
+}
+
+// acquire returns the reader of the table with the given id, opening it if
+// it is not cached. The reader must be released after use.
+func (c *tableCache) acquire(id int) (*cachedTable, error) {
+	c.mu.Lock()
+	if table := c.lookup(id); table != nil {
+		c.mu.Unlock()
+		return table, nil
+	}
+	c.mu.Unlock()
+
+	// the table is opened without the lock, so the lookups of the cached
+	// tables are not blocked by the file reads.
+	reader, err := openSsTable(c.dbDir, id)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// the table may have been opened by the concurrent lookup.
+	if table := c.lookup(id); table != nil {
+		_ = reader.close()
+		return table, nil
+	}
+
+	table := &cachedTable{id: id, reader: reader, refs: 2}
+	c.tables[id] = c.lru.PushFront(table)
+	for c.lru.Len() > c.capacity {
+		c.remove(c.lru.Back())
+	}
+	return table, nil
+}
+
+// lookup returns the cached table with the given id and references it,
+// or nil if it is not cached. The caller must hold mu.
+func (c *tableCache) lookup(id int) *cachedTable {
+	element, ok := c.tables[id]
+	if !ok {
+		return nil
+	}
+
+	c.lru.MoveToFront(element)
+	table := element.Value.(*cachedTable)
+	table.refs++
+	return table
+}
+
+// release releases the reader returned by acquire.
+func (c *tableCache) release(table *cachedTable) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.unref(table)
+}
+
+// evict removes the table with the given id from the cache, it is called
+// before the table is deleted.
+func (c *tableCache) evict(id int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.tables[id]; ok {
+		c.remove(element)
+	}
+}
+
+// close evicts all tables.
+func (c *tableCache) close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for c.lru.Len() > 0 {
+		c.remove(c.lru.Back())
+	}
+}
+
+// remove removes the element from the cache. The caller must hold mu.
+func (c *tableCache) remove(element *list.Element) {
+	table := c.lru.Remove(element).(*cachedTable)
+	delete(c.tables, table.id)
+	c.unref(table)
+}
+
+// unref drops the reference of the table and closes its reader once it is
+// not referenced. The caller must hold mu.
+func (c *tableCache) unref(table *cachedTable) {
+	table.refs--
+	if table.refs == 0 {
+		// the reader only reads the file, so the close error is harmless.
+		_ = table.reader.close()
+	}
+}
@@ -0,0 +1,109 @@
+package lsmtree
+
+import (
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestTableCache(t *testing.T) {
+	dbDir, close, err := prepareSsTable(prepareMemTable(), 0, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer close()
+	if _, err := createSsTable(prepareMemTable(), dbDir, 1, ssTableOptions{sparseKeyDistance: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	cache := newTableCache(dbDir, 1)
+	defer cache.close()
+
+	e, ok, err := cache.find(0, []byte("c"), maxSequence)
+	if err != nil || !ok || string(e.value) != "vc" {
+		t.Fatalf("find expected value=vc, actual value=%s ok=%v err=%v", e.value, ok, err)
+	}
+	first, err := cache.acquire(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := cache.acquire(0); err != nil || again != first {
+		t.Fatalf("acquire expected the cached reader, actual err=%v", err)
+	} else {
+		cache.release(again)
+	}
+
+	// the evicted reader is closed once it is released.
+	if _, _, err := cache.find(1, []byte("c"), maxSequence); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.tables[0]; ok || cache.lru.Len() != 1 {
+		t.Fatalf("the least recently used table must be evicted, cached=%d", cache.lru.Len())
+	}
+	if _, _, err := first.reader.find([]byte("c"), maxSequence); err != nil {
+		t.Fatalf("the acquired reader must stay open, actual err=%v", err)
+	}
+	cache.release(first)
+	if _, _, err := first.reader.find([]byte("c"), maxSequence); err == nil {
+		t.Fatalf("the released reader of the evicted table must be closed")
+	}
+
+	cache.evict(1)
+	if cache.lru.Len() != 0 {
+		t.Fatalf("the evicted table must not be cached")
+	}
+}
+
+func TestTableCache_Iterator(t *testing.T) {
+	tree, closer := prepareTree(t, TableCacheSize(100))
+	defer closer()
+
+	// the overwritten keys make the tables overlap, so they are merged into
+	// the deeper level, the newer tables stay at level 0.
+	tree.PauseCompactions()
+	putRange(t, tree, 0, 100)
+	putRange(t, tree, 0, 100)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 100, 200)
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := tree.NewIterator(nil, nil)
+	if err != nil {
+		t.Fatalf("NewIterator error: %s", err)
+	}
+	count := 0
+	for ; it.Valid(); count++ {
+		if err := it.Next(); err != nil {
+			t.Fatalf("Next error: %s", err)
+		}
+	}
+	if count != 200 {
+		t.Fatalf("iterator expected 200 keys, actual %d", count)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatalf("Close error: %s", err)
+	}
+
+	// the iterator reads the tables through the cache and releases them.
+	tree.mu.RLock()
+	defer tree.mu.RUnlock()
+	for level, tables := range tree.version.levels {
+		for _, m := range tables {
+			element, ok := tree.tableCache.tables[m.id]
+			if !ok {
+				t.Fatalf("sstable %d at level %d must be cached", m.id, level)
+			}
+			if refs := element.Value.(*cachedTable).refs; refs != 1 {
+				t.Fatalf("sstable %d must be released by the iterator, refs=%d", m.id, refs)
+			}
+		}
+	}
+}
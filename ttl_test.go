@@ -0,0 +1,145 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestWriteBatch_PutWithTTL(t *testing.T) {
+	batch := NewWriteBatch()
+	batch.PutWithTTL([]byte("a"), []byte("1"), time.Hour)
+	batch.Put([]byte("b"), []byte("2"))
+
+	data, err := encodeBatch(batch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeBatch(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, e := range decoded.entries {
+		expected := batch.entries[i]
+		if string(e.key) != string(expected.key) || string(e.value) != string(expected.value) || e.expiresAt != expected.expiresAt {
+			t.Fatalf("decodeBatch entry %d expected %v, actual %v", i, expected, e)
+		}
+	}
+
+	value, err := decodeInternalValue(encodeInternalValue(entry{value: []byte("1"), seq: 3, expiresAt: 5}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(value.value) != "1" || value.seq != 3 || value.expiresAt != 5 {
+		t.Fatalf("decodeInternalValue expected value=1 seq=3 expiresAt=5, actual %v", value)
+	}
+	if !value.expired(5) || value.expired(4) {
+		t.Fatalf("the value must expire at its expiration time")
+	}
+}
+
+func TestLSMTree_PutWithTTL(t *testing.T) {
+	tree, close := prepareTree(t)
+	defer close()
+
+	putRange(t, tree, 0, 10)
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	const ttl = 50 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		if err := tree.PutWithTTL([]byte(fmt.Sprintf("key-%04d", i)), []byte("ttl"), ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.PutWithTTL([]byte("key-0005"), []byte(""), ttl); err != ErrValueRequired {
+		t.Fatalf("PutWithTTL expected err=%v, actual err=%v", ErrValueRequired, err)
+	}
+	if value, ok, err := tree.Get([]byte("key-0000")); err != nil || !ok || string(value) != "ttl" {
+		t.Fatalf("Get expected value=ttl, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+
+	time.Sleep(2 * ttl)
+	countKeys := func() int {
+		t.Helper()
+		it, err := tree.NewIterator(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer it.Close()
+		count := 0
+		for ; it.Valid(); count++ {
+			if err := it.Next(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return count
+	}
+	check := func() {
+		t.Helper()
+		for i := 0; i < 5; i++ {
+			key := []byte(fmt.Sprintf("key-%04d", i))
+			if _, ok, err := tree.Get(key); err != nil || ok {
+				t.Fatalf("Get key=%s expected the expired key, actual ok=%v err=%v", key, ok, err)
+			}
+		}
+		checkRange(t, tree, 5, 10)
+		if count := countKeys(); count != 5 {
+			t.Fatalf("iterator expected 5 keys, actual %d", count)
+		}
+	}
+
+	check()
+	// the expired values are removed by the compaction.
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	check()
+	if expired := tree.CompactionStats().ExpiredValues; expired != 5 {
+		t.Fatalf("the compaction expected to drop 5 expired values, actual %d", expired)
+	}
+	if records := recordNum(t, tree); records != 5 {
+		t.Fatalf("the expired values must be dropped, expected 5 records, actual %d", records)
+	}
+}
+
+func TestLSMTree_CompactExpiredTables(t *testing.T) {
+	tree, close := prepareTree(t, MemTableSizeThreshold(defaultMemTableThreshold))
+	defer close()
+
+	// the expired values are moved to the last level without overlapping any
+	// table, so only their expiration schedules the compaction.
+	const ttl = 50 * time.Millisecond
+	for i := 0; i < 5; i++ {
+		if err := tree.PutWithTTL([]byte(fmt.Sprintf("key-%04d", i)), []byte("ttl"), ttl); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.CompactRange(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if records := recordNum(t, tree); records != 5 {
+		t.Fatalf("the values must be kept until they expire, expected 5 records, actual %d", records)
+	}
+	tree.mu.RLock()
+	tables := tree.version.levels[levelNum-1]
+	tree.mu.RUnlock()
+	if len(tables) != tableNum(tree) || tables[0].minExpiresAt == 0 {
+		t.Fatalf("the table with the expiration expected in the last level, actual %d of %d tables", len(tables), tableNum(tree))
+	}
+
+	time.Sleep(2 * ttl)
+	if err := tree.WaitForCompactions(); err != nil {
+		t.Fatal(err)
+	}
+	if expired := tree.CompactionStats().ExpiredValues; expired != 5 {
+		t.Fatalf("the compaction expected to drop 5 expired values, actual %d", expired)
+	}
+	if records := recordNum(t, tree); records != 0 {
+		t.Fatalf("the expired values must be dropped, expected 0 records, actual %d", records)
+	}
+}
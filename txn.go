@@ -0,0 +1,254 @@
+package lsmtree
+
+import (
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// defaultTxnLockTimeout is default time the pessimistic transaction waits for the key lock.
+	defaultTxnLockTimeout = time.Second
+)
+
+// TxnOptions are the options of the transaction.
+type TxnOptions struct {
+	// Pessimistic makes the transaction lock the keys instead of checking
+	// the conflicts on the commit, see Txn.
+	Pessimistic bool
+	// LockTimeout is the max time the pessimistic transaction waits for the
+	// key lock, 0 means default timeout and the negative value means no limit.
+	LockTimeout time.Duration
+}
+
+// Txn is the transaction. It reads the state of the tree at the moment of its
+// creation together with its own writes, which are buffered until the commit.
+//
+// The optimistic transaction does not block other writers. Its commit fails
+// with ErrConflict if any key read or written by the transaction was changed
+// by another writer since the transaction began, then the transaction may be
+// retried.
+//
+// The pessimistic transaction locks the key before writing it and GetForUpdate
+// locks the key before reading it, the locks are held until the transaction is
+// finished. The transaction waiting for the lock held by another transaction
+// is blocked until the lock is released or the lock timeout expires, then
+// ErrLockTimeout is returned. ErrDeadlock is returned if the transactions
+// wait for each other. The transaction should be rolled back on both errors.
+// The locks coordinate only the transactions, the writes outside of them do
+// not wait for the locks.
+//
+// Txn is not safe for concurrent use by multiple goroutines.
+type Txn struct {
+	t        *LSMTree
+	snapshot *Snapshot
+	batch    *WriteBatch
+	// writes are the buffered values of the written keys, the nil value
+	// marks the deleted key.
+	writes map[string][]byte
+	// reads are the keys read by the optimistic transaction.
+	reads map[string]struct{}
+	done  bool
+
+	// id identifies the pessimistic transaction in the key locks.
+	id          uint64
+	pessimistic bool
+	lockTimeout time.Duration
+	// locked are the keys locked by the pessimistic transaction.
+	locked map[string]struct{}
+}
+
+// Begin begins the optimistic transaction. The transaction must be finished
+// by Commit or Rollback.
+func (t *LSMTree) Begin() *Txn {
+	return t.BeginWithOptions(TxnOptions{})
+}
+
+// BeginWithOptions begins the transaction with the given options. The
+// transaction must be finished by Commit or Rollback.
+func (t *LSMTree) BeginWithOptions(options TxnOptions) *Txn {
+	txn := &Txn{
+		t:           t,
+		snapshot:    t.NewSnapshot(),
+		batch:       NewWriteBatch(),
+		writes:      make(map[string][]byte),
+		reads:       make(map[string]struct{}),
+		locked:      make(map[string]struct{}),
+		pessimistic: options.Pessimistic,
+		lockTimeout: options.LockTimeout,
+	}
+	if txn.lockTimeout == 0 {
+		txn.lockTimeout = defaultTxnLockTimeout
+	}
+	if txn.pessimistic {
+		txn.id = t.txnLocks.newID()
+	}
+
+	return txn
+}
+
+// Get returns the value according to the key. The value written by the
+// transaction is returned if any.
+func (txn *Txn) Get(key []byte) ([]byte, bool, error) {
+	if txn.done {
+		return nil, false, ErrTxnDone
+	}
+
+	if value, exists := txn.writes[string(key)]; exists {
+		return value, value != nil, nil
+	}
+
+	if !txn.pessimistic {
+		txn.reads[string(key)] = struct{}{}
+	}
+	return txn.snapshot.Get(key)
+}
+
+// GetForUpdate returns the value according to the key like Get, but the
+// pessimistic transaction locks the key and reads its latest value, so it
+// is not changed by other transactions until this one is finished.
+func (txn *Txn) GetForUpdate(key []byte) ([]byte, bool, error) {
+	if !txn.pessimistic {
+		return txn.Get(key)
+	}
+	if txn.done {
+		return nil, false, ErrTxnDone
+	}
+
+	if err := txn.lock(key); err != nil {
+		return nil, false, err
+	}
+	if value, exists := txn.writes[string(key)]; exists {
+		return value, value != nil, nil
+	}
+	return txn.t.Get(key)
+}
+
+// Put puts the key-value pair, it is written when the transaction is committed.
+func (txn *Txn) Put(key, value []byte) error {
+	return txn.write(key, value)
+}
+
+// Delete deletes the value by key, it is deleted when the transaction is committed.
+func (txn *Txn) Delete(key []byte) error {
+	return txn.write(key, nil)
+}
+
+// write buffers the mutation of the key, the nil value is the deletion.
+func (txn *Txn) write(key, value []byte) error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	if err := validateEntry(key, value); err != nil {
+		return err
+	}
+	if txn.pessimistic {
+		if err := txn.lock(key); err != nil {
+			return err
+		}
+	}
+
+	if value != nil {
+		txn.batch.Put(key, value)
+	} else {
+		txn.batch.Delete(key)
+	}
+	txn.writes[string(key)] = txn.batch.entries[txn.batch.Len()-1].value
+	return nil
+}
+
+// Commit atomically writes all mutations of the transaction. The optimistic
+// transaction returns ErrConflict if any key read or written by it was changed
+// since the transaction began, then none of the mutations is written.
+// The transaction is finished even if the commit fails.
+func (txn *Txn) Commit() error {
+	if txn.done {
+		return ErrTxnDone
+	}
+	defer txn.finish()
+
+	if txn.t.readOnly && txn.batch.Len() > 0 {
+		return ErrReadOnly
+	}
+	if txn.pessimistic {
+		if txn.batch.Len() == 0 {
+			return nil
+		}
+		return txn.t.commitGroup([]*writer{{batch: txn.batch}})
+	}
+
+	// the writer holding writeMu sees all committed writes and no other
+	// write may be committed until it finishes.
+	if txn.batch.Len() == 0 {
+		txn.t.writeMu.Lock()
+		defer txn.t.writeMu.Unlock()
+		return txn.checkConflicts()
+	}
+
+	return txn.t.commitGroup([]*writer{{batch: txn.batch, check: txn.checkConflicts}})
+}
+
+// Rollback discards all mutations of the transaction. It is safe to call
+// Rollback after Commit.
+func (txn *Txn) Rollback() {
+	if !txn.done {
+		txn.finish()
+	}
+}
+
+// finish releases the snapshot and the locks of the transaction.
+func (txn *Txn) finish() {
+	txn.done = true
+	txn.snapshot.Release()
+	if txn.pessimistic {
+		txn.t.txnLocks.unlock(txn.id, txn.locked)
+	}
+}
+
+// lock locks the key for the pessimistic transaction.
+func (txn *Txn) lock(key []byte) error {
+	if _, locked := txn.locked[string(key)]; locked {
+		return nil
+	}
+
+	if err := txn.t.txnLocks.lock(txn.id, string(key), txn.lockTimeout); err != nil {
+		return err
+	}
+	txn.locked[string(key)] = struct{}{}
+	return nil
+}
+
+// checkConflicts returns ErrConflict if any key read or written by the
+// transaction has the version newer than the transaction snapshot.
+// The caller must hold writeMu.
+func (txn *Txn) checkConflicts() error {
+	for key := range txn.writes {
+		if err := txn.checkKey([]byte(key)); err != nil {
+			return err
+		}
+	}
+	for key := range txn.reads {
+		if err := txn.checkKey([]byte(key)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkKey returns ErrConflict if the key has the version or the range
+// tombstone newer than the transaction snapshot. The caller must hold writeMu.
+func (txn *Txn) checkKey(key []byte) error {
+	e, exists, rangeDels, err := txn.t.find(key, maxSequence)
+	if err != nil {
+		return err
+	}
+	if exists && e.seq > txn.snapshot.seq {
+		return ErrConflict
+	}
+	if rangeDels.maxCovering(key) > txn.snapshot.seq {
+		return ErrConflict
+	}
+	return nil
+}
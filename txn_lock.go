@@ -0,0 +1,123 @@
+package lsmtree
+
+import (
+	"sync"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+// keyLocks are the locks of the keys held by the pessimistic transactions.
+// The waiting transactions form the wait-for graph, the lock request which
+// would close the cycle in the graph fails with ErrDeadlock.
+type keyLocks struct {
+	mu sync.Mutex
+	// locks are the held locks by key.
+	locks map[string]*keyLock
+	// waitFor is the wait-for graph: the waiting transaction points to the
+	// transaction holding the lock. The transaction waits for one lock at a time.
+	waitFor map[uint64]uint64
+	// nextID is the id of the next transaction.
+	nextID uint64
+}
+
+// keyLock is the lock of the single key.
+type keyLock struct {
+	// owner is the id of the transaction holding the lock.
+	owner uint64
+	// released is closed when the lock is released.
+	released chan struct{}
+}
+
+// newKeyLocks creates a new instance of the key locks.
+func newKeyLocks() *keyLocks {
+	return &keyLocks{
+		locks:   make(map[string]*keyLock),
+		waitFor: make(map[uint64]uint64),
+		nextID:  1,
+	}
+}
+
+// newID returns the unique id of the transaction.
+func (l *keyLocks) newID() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	id := l.nextID
+	l.nextID++
+	return id
+}
+
+// lock acquires the lock of the key for the transaction with the given id,
+// the lock held by the transaction is acquired immediately. It waits for the
+// lock at most the given timeout, the non-positive timeout means no limit.
+// Returns ErrLockTimeout if the timeout expires and ErrDeadlock if the
+// transaction holding the lock waits for this transaction.
+func (l *keyLocks) lock(id uint64, key string, timeout time.Duration) error {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for {
+		kl, locked := l.locks[key]
+		if !locked {
+			l.locks[key] = &keyLock{owner: id, released: make(chan struct{})}
+			return nil
+		}
+		if kl.owner == id {
+			return nil
+		}
+		if l.waits(kl.owner, id) {
+			return ErrDeadlock
+		}
+
+		l.waitFor[id] = kl.owner
+		l.mu.Unlock()
+
+		timeout := false
+		select {
+		case <-kl.released:
+		case <-deadline:
+			timeout = true
+		}
+
+		l.mu.Lock()
+		delete(l.waitFor, id)
+		if timeout {
+			return ErrLockTimeout
+		}
+	}
+}
+
+// waits returns true if the transaction from waits for the transaction to
+// directly or through the other transactions. The caller must hold mu.
+func (l *keyLocks) waits(from, to uint64) bool {
+	for id := from; id != to; {
+		next, waiting := l.waitFor[id]
+		if !waiting {
+			return false
+		}
+		id = next
+	}
+	return true
+}
+
+// unlock releases the locks of the keys held by the transaction with the given id.
+func (l *keyLocks) unlock(id uint64, keys map[string]struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for key := range keys {
+		if kl, locked := l.locks[key]; locked && kl.owner == id {
+			delete(l.locks, key)
+			close(kl.released)
+		}
+	}
+}
@@ -0,0 +1,292 @@
+package lsmtree
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestTxn(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	if err := tree.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn := tree.Begin()
+	if err := txn.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte(""), []byte("invalid")); err != ErrKeyRequired {
+		t.Fatalf("Put expected err=%v, actual err=%v", ErrKeyRequired, err)
+	}
+	if value, ok, err := txn.Get([]byte("b")); err != nil || !ok || string(value) != "2" {
+		t.Fatalf("the transaction must read its writes, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if _, ok, err := txn.Get([]byte("a")); err != nil || ok {
+		t.Fatalf("the transaction must read its deletes, actual ok=%v err=%v", ok, err)
+	}
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "1" {
+		t.Fatalf("the writes must not be visible before the commit, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok, err := tree.Get([]byte("a")); err != nil || ok {
+		t.Fatalf("the committed delete must be visible, actual ok=%v err=%v", ok, err)
+	}
+	if value, ok, err := tree.Get([]byte("b")); err != nil || !ok || string(value) != "2" {
+		t.Fatalf("the committed put must be visible, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+	if err := txn.Commit(); err != ErrTxnDone {
+		t.Fatalf("Commit expected err=%v, actual err=%v", ErrTxnDone, err)
+	}
+
+	txn = tree.Begin()
+	if err := txn.Put([]byte("c"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	txn.Rollback()
+	if _, ok, err := tree.Get([]byte("c")); err != nil || ok {
+		t.Fatalf("the rolled back put must not be visible, actual ok=%v err=%v", ok, err)
+	}
+	if _, _, err := txn.Get([]byte("c")); err != ErrTxnDone {
+		t.Fatalf("Get expected err=%v, actual err=%v", ErrTxnDone, err)
+	}
+}
+
+func TestTxn_Conflict(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	// the key read by the transaction is changed.
+	txn := tree.Begin()
+	if _, _, err := txn.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte("b"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("a"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrConflict {
+		t.Fatalf("Commit expected err=%v, actual err=%v", ErrConflict, err)
+	}
+	if _, ok, err := tree.Get([]byte("b")); err != nil || ok {
+		t.Fatalf("the conflicting transaction must not be written, actual ok=%v err=%v", ok, err)
+	}
+
+	// the key written by the transaction is deleted and flushed.
+	txn = tree.Begin()
+	if err := txn.Put([]byte("a"), []byte("3")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != ErrConflict {
+		t.Fatalf("Commit expected err=%v, actual err=%v", ErrConflict, err)
+	}
+
+	// the other keys are changed.
+	txn = tree.Begin()
+	if _, _, err := txn.Get([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Put([]byte("a"), []byte("4")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("c"), []byte("5")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "4" {
+		t.Fatalf("Get expected value=4, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+}
+
+func TestTxn_Counter(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	const workers, increments = 8, 20
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; {
+				txn := tree.Begin()
+				value, _, err := txn.Get(key)
+				if err != nil {
+					t.Errorf("Get error: %s", err)
+					return
+				}
+				counter, _ := strconv.Atoi(string(value))
+				if err := txn.Put(key, []byte(strconv.Itoa(counter+1))); err != nil {
+					t.Errorf("Put error: %s", err)
+					return
+				}
+
+				if err := txn.Commit(); err == nil {
+					i++
+				} else if err != ErrConflict {
+					t.Errorf("Commit error: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, ok, err := tree.Get(key)
+	if err != nil || !ok || string(value) != strconv.Itoa(workers*increments) {
+		t.Fatalf("Get expected value=%d, actual value=%s ok=%v err=%v", workers*increments, value, ok, err)
+	}
+}
+
+func TestTxn_Pessimistic(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	txn1 := tree.BeginWithOptions(TxnOptions{Pessimistic: true})
+	if err := txn1.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+
+	txn2 := tree.BeginWithOptions(TxnOptions{Pessimistic: true, LockTimeout: 10 * time.Millisecond})
+	if err := txn2.Put([]byte("a"), []byte("2")); err != ErrLockTimeout {
+		t.Fatalf("Put expected err=%v, actual err=%v", ErrLockTimeout, err)
+	}
+	txn2.Rollback()
+
+	done := make(chan error, 1)
+	go func() {
+		txn := tree.BeginWithOptions(TxnOptions{Pessimistic: true, LockTimeout: -1})
+		value, _, err := txn.GetForUpdate([]byte("a"))
+		if err == nil {
+			err = txn.Put([]byte("a"), append(value, '2'))
+		}
+		if err == nil {
+			err = txn.Commit()
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Fatalf("the transaction must wait for the lock, actual err=%v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	if err := txn1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if value, ok, err := tree.Get([]byte("a")); err != nil || !ok || string(value) != "12" {
+		t.Fatalf("Get expected value=12, actual value=%s ok=%v err=%v", value, ok, err)
+	}
+}
+
+func TestTxn_Deadlock(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	txn1 := tree.BeginWithOptions(TxnOptions{Pessimistic: true, LockTimeout: -1})
+	txn2 := tree.BeginWithOptions(TxnOptions{Pessimistic: true, LockTimeout: -1})
+	if err := txn1.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := txn2.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		err := txn1.Put([]byte("b"), []byte("1"))
+		if err == nil {
+			err = txn1.Commit()
+		}
+		done <- err
+	}()
+
+	// wait until txn1 waits for txn2.
+	for {
+		tree.txnLocks.mu.Lock()
+		_, waiting := tree.txnLocks.waitFor[txn1.id]
+		tree.txnLocks.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if _, _, err := txn2.GetForUpdate([]byte("a")); err != ErrDeadlock {
+		t.Fatalf("GetForUpdate expected err=%v, actual err=%v", ErrDeadlock, err)
+	}
+	txn2.Rollback()
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b"} {
+		if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != "1" {
+			t.Fatalf("Get key=%s expected value=1, actual value=%s ok=%v err=%v", key, value, ok, err)
+		}
+	}
+}
+
+func TestTxn_PessimisticCounter(t *testing.T) {
+	tree, closer := prepareTree(t)
+	defer closer()
+
+	const workers, increments = 8, 20
+	key := []byte("counter")
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < increments; i++ {
+				txn := tree.BeginWithOptions(TxnOptions{Pessimistic: true, LockTimeout: -1})
+				value, _, err := txn.GetForUpdate(key)
+				if err != nil {
+					t.Errorf("GetForUpdate error: %s", err)
+					return
+				}
+				counter, _ := strconv.Atoi(string(value))
+				if err := txn.Put(key, []byte(strconv.Itoa(counter+1))); err != nil {
+					t.Errorf("Put error: %s", err)
+					return
+				}
+				if err := txn.Commit(); err != nil {
+					t.Errorf("Commit error: %s", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	value, ok, err := tree.Get(key)
+	if err != nil || !ok || string(value) != strconv.Itoa(workers*increments) {
+		t.Fatalf("Get expected value=%d, actual value=%s ok=%v err=%v", workers*increments, value, ok, err)
+	}
+}
@@ -0,0 +1,431 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+const (
+	// levelNum is the number of levels. Level 0 contains flushed SSTables with
+	// overlapping key ranges, the deeper levels contain non-overlapping SSTables.
+	levelNum = 7
+	// legacyMetaSize is the size of the meta file which contains only the number
+	// of SSTables and the max SSTable index.
+	legacyMetaSize = 16
+)
+
+// tableMeta describes a single SSTable.
+type tableMeta struct {
+	// id is the unique number of the table, its files are prefixed by it.
+	id int
+	// size is the size of the data file in bytes.
+	size int
+	// smallest and largest are the bounds of the table keys.
+	smallest, largest []byte
+	// garbageSeq is the greatest sequence number of the snapshots which may
+	// need the tombstones or the shadowed versions of the table, plus one.
+	// Once no older snapshot exists, rewriting the table in the last level
+	// drops them. 0 means the table has no such records.
+	garbageSeq uint64
+	// minExpiresAt is the earliest expiration time of the table values in Unix
+	// nanoseconds, 0 means the table has no expiring values.
+	minExpiresAt int64
+	// filter is the bloom filter of the table keys, it is read on the first
+	// lookup if the table is not created by this instance.
+	filter     bloomFilter
+	filterOnce sync.Once
+	// refs is the number of the referenced versions containing the table.
+	// The table is deleted once it is not referenced.
+	refs int32
+}
+
+// overlaps returns true if the table contains keys in range [start, end].
+// The nil start and end mean the range is not bounded.
+func (m *tableMeta) overlaps(start, end []byte) bool {
+	return (end == nil || bytes.Compare(m.smallest, end) <= 0) &&
+		(start == nil || bytes.Compare(m.largest, start) >= 0)
+}
+
+// hasGarbage returns true if the table has the tombstones or the shadowed
+// versions which are invisible to all snapshots, given the sequence number
+// of the oldest one.
+func (m *tableMeta) hasGarbage(oldestSnapshot uint64) bool {
+	return m.garbageSeq > 0 && m.garbageSeq <= oldestSnapshot
+}
+
+// hasExpired returns true if the table has the values expired by now.
+func (m *tableMeta) hasExpired(now int64) bool {
+	return m.minExpiresAt != 0 && m.minExpiresAt <= now
+}
+
+// mayContain returns false if the key is definitely not in the table. The table
+// without the filter, or whose filter cannot be read, may contain any key.
+func (m *tableMeta) mayContain(dbDir string, key []byte) bool {
+	m.filterOnce.Do(func() {
+		if m.filter == nil {
+			m.filter, _ = readFilter(dbDir, m.id)
+		}
+	})
+	return m.filter.mayContain(key)
+}
+
+// version is the set of live SSTables. It is never changed after creation,
+// the changes are applied by creating a new version.
+type version struct {
+	// nextFileNumber is the id of the next created table or WAL segment.
+	nextFileNumber int
+	// logNumber is the number of the oldest WAL segment whose changes are not
+	// in the tables yet. The older segments are obsolete.
+	logNumber int
+	// lastSequence is the sequence number of the last write when the version
+	// was created. The writes in the live WAL segments follow it.
+	lastSequence uint64
+	// levels holds the tables of each level. Level 0 is ordered from the oldest
+	// to the newest table, the others are ordered by the smallest key.
+	levels [levelNum][]*tableMeta
+	// rangeDels are the range tombstones of the flushed MemTables.
+	rangeDels rangeTombstones
+	// rangeDelIndex is the index of rangeDels used by the lookups.
+	rangeDelIndex rangeDelIndex
+	// refs is the number of the references of the version. The tree holds
+	// one while the version is current, the lookups hold one while they read
+	// the tables, so the tables are not deleted meanwhile.
+	refs int32
+}
+
+// ref references the version. The version must be current or referenced.
+func (v *version) ref() {
+	if atomic.AddInt32(&v.refs, 1) > 1 {
+		return
+	}
+	for level := 0; level < levelNum; level++ {
+		for _, m := range v.levels[level] {
+			atomic.AddInt32(&m.refs, 1)
+		}
+	}
+}
+
+// unref drops the reference of the version. Returns the tables which are
+// not in any referenced version, they are obsolete.
+func (v *version) unref() []*tableMeta {
+	if atomic.AddInt32(&v.refs, -1) > 0 {
+		return nil
+	}
+	var obsolete []*tableMeta
+	for level := 0; level < levelNum; level++ {
+		for _, m := range v.levels[level] {
+			if atomic.AddInt32(&m.refs, -1) == 0 {
+				obsolete = append(obsolete, m)
+			}
+		}
+	}
+	return obsolete
+}
+
+// versionEdit describes the change of the version.
+type versionEdit struct {
+	// added are tables added to the level.
+	added [levelNum][]*tableMeta
+	// deleted are ids of tables deleted from the level.
+	deleted [levelNum][]int
+	// logNumber is the new number of the oldest live WAL segment, 0 if it is
+	// not changed. It is set by the flush together with the flushed table.
+	logNumber int
+	// nextFileNumber is the id of the next created file when the edit is committed.
+	nextFileNumber int
+	// lastSequence is the sequence number of the last write when the edit is
+	// committed, 0 if it is not changed.
+	lastSequence uint64
+	// rangeDels are the added range tombstones.
+	rangeDels rangeTombstones
+	// deletedRangeDels are the sequence numbers of the deleted range tombstones.
+	deletedRangeDels []uint64
+}
+
+// addTable adds the table to the level.
+func (e *versionEdit) addTable(level int, m *tableMeta) {
+	e.added[level] = append(e.added[level], m)
+}
+
+// addedTable returns the added table with the given id, or nil if there is none.
+func (e *versionEdit) addedTable(id int) *tableMeta {
+	for _, tables := range e.added {
+		for _, m := range tables {
+			if m.id == id {
+				return m
+			}
+		}
+	}
+	return nil
+}
+
+// deleteTable deletes the table from the level.
+func (e *versionEdit) deleteTable(level int, id int) {
+	e.deleted[level] = append(e.deleted[level], id)
+}
+
+// deleteRangeTombstone deletes the range tombstone with the sequence number.
+func (e *versionEdit) deleteRangeTombstone(seq uint64) {
+	e.deletedRangeDels = append(e.deletedRangeDels, seq)
+}
+
+// apply creates a new version by applying the edit.
+func (v *version) apply(e *versionEdit, nextFileNumber int) *version {
+	nv := &version{nextFileNumber: nextFileNumber, logNumber: v.logNumber, lastSequence: v.lastSequence}
+	if e.logNumber > 0 {
+		nv.logNumber = e.logNumber
+	}
+	if e.lastSequence > nv.lastSequence {
+		nv.lastSequence = e.lastSequence
+	}
+	nv.rangeDels, nv.rangeDelIndex = v.rangeDels, v.rangeDelIndex
+	if len(e.rangeDels) > 0 || len(e.deletedRangeDels) > 0 {
+		deleted := make(map[uint64]bool, len(e.deletedRangeDels))
+		for _, seq := range e.deletedRangeDels {
+			deleted[seq] = true
+		}
+
+		nv.rangeDels = make(rangeTombstones, 0, len(v.rangeDels)+len(e.rangeDels))
+		for _, r := range v.rangeDels {
+			if !deleted[r.seq] {
+				nv.rangeDels = append(nv.rangeDels, r)
+			}
+		}
+		nv.rangeDels = append(nv.rangeDels, e.rangeDels...)
+		nv.rangeDelIndex = newRangeDelIndex(nv.rangeDels)
+	}
+	for level := 0; level < levelNum; level++ {
+		deleted := make(map[int]bool, len(e.deleted[level]))
+		for _, id := range e.deleted[level] {
+			deleted[id] = true
+		}
+
+		// the tables added to level 0 in place of the deleted ones are the result
+		// of their merge, so they take the place of the first deleted table to be
+		// shadowed by the newer tables. Otherwise, they are the newest tables.
+		tables := make([]*tableMeta, 0, len(v.levels[level])+len(e.added[level]))
+		inserted := false
+		for _, m := range v.levels[level] {
+			if !deleted[m.id] {
+				tables = append(tables, m)
+			} else if level == 0 && !inserted {
+				tables = append(tables, e.added[level]...)
+				inserted = true
+			}
+		}
+		if !inserted {
+			tables = append(tables, e.added[level]...)
+		}
+
+		if level > 0 {
+			sort.Slice(tables, func(i, j int) bool {
+				return bytes.Compare(tables[i].smallest, tables[j].smallest) < 0
+			})
+		}
+		nv.levels[level] = tables
+	}
+
+	return nv
+}
+
+// tableNum returns the number of all tables.
+func (v *version) tableNum() int {
+	num := 0
+	for _, tables := range v.levels {
+		num += len(tables)
+	}
+	return num
+}
+
+// levelSize returns the total size of tables in the level.
+func (v *version) levelSize(level int) int {
+	size := 0
+	for _, m := range v.levels[level] {
+		size += m.size
+	}
+	return size
+}
+
+// overlappingTables returns the tables of the level which contain keys in range [start, end].
+func (v *version) overlappingTables(level int, start, end []byte) []*tableMeta {
+	tables := make([]*tableMeta, 0)
+	for _, m := range v.levels[level] {
+		if m.overlaps(start, end) {
+			tables = append(tables, m)
+		}
+	}
+	return tables
+}
+
+// find searches the version of the key in the tables from the newest to the oldest,
+// the versions with the sequence number greater than the given one are skipped.
+// The tables are read through the table cache.
+func (v *version) find(cache *tableCache, key []byte, seq uint64) (entry, bool, error) {
+	l0 := v.levels[0]
+	for i := len(l0) - 1; i >= 0; i-- {
+		if !l0[i].overlaps(key, key) || !l0[i].mayContain(cache.dbDir, key) {
+			continue
+		}
+
+		e, exists, err := cache.find(l0[i].id, key, seq)
+		if err != nil {
+			return entry{}, false, fmt.Errorf("failed to search in sstable %d: %w", l0[i].id, err)
+		}
+		if exists {
+			return e, true, nil
+		}
+	}
+
+	for level := 1; level < levelNum; level++ {
+		tables := v.levels[level]
+		i := sort.Search(len(tables), func(i int) bool {
+			return bytes.Compare(tables[i].largest, key) >= 0
+		})
+		if i == len(tables) || bytes.Compare(tables[i].smallest, key) > 0 || !tables[i].mayContain(cache.dbDir, key) {
+			continue
+		}
+
+		e, exists, err := cache.find(tables[i].id, key, seq)
+		if err != nil {
+			return entry{}, false, fmt.Errorf("failed to search in sstable %d: %w", tables[i].id, err)
+		}
+		if exists {
+			return e, true, nil
+		}
+	}
+
+	return entry{}, false, nil
+}
+
+// readVersion reads the version from the meta file written by the older version,
+// before the MANIFEST. The meta file which contains only the number of tables
+// and the max table index is converted and all the tables are placed in level 0.
+func readVersion(dbDir string) (*version, error) {
+	filePath := path.Join(dbDir, ssTableMetaFileName)
+	data, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &version{}, nil
+		}
+		return nil, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+
+	if len(data) == legacyMetaSize {
+		v, err := readLegacyVersion(dbDir, data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert file %s: %w", filePath, err)
+		}
+		return v, nil
+	}
+
+	v, err := decodeVersion(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode file %s: %w", filePath, err)
+	}
+
+	return v, nil
+}
+
+// decodeVersion decodes the version from the meta file.
+//
+//	Encode format:
+//	[next file number][level number]
+//	[table number of level 0][table][table]...
+//	...
+//	[table number of level n][table][table]...
+//	[log number]
+//	Table format:
+//	[id][size][encoded smallest and largest key]
+func decodeVersion(r io.Reader) (*version, error) {
+	var buf [16]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return nil, err
+	}
+
+	v := &version{}
+	nextFileNumber, levels := decodeIntPair(buf[:])
+	v.nextFileNumber = nextFileNumber
+	if levels != levelNum {
+		return nil, fmt.Errorf("unexpected level number %d", levels)
+	}
+
+	for level := 0; level < levelNum; level++ {
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return nil, err
+		}
+
+		num := decodeInt(buf[:8])
+		v.levels[level] = make([]*tableMeta, 0, num)
+		for i := 0; i < num; i++ {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return nil, err
+			}
+			id, size := decodeIntPair(buf[:])
+
+			smallest, largest, err := decode(r)
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode table %d: %w", id, err)
+			}
+			v.levels[level] = append(v.levels[level], &tableMeta{id: id, size: size, smallest: smallest, largest: largest})
+		}
+	}
+
+	// the meta file written before WAL segments has no log number.
+	if _, err := io.ReadFull(r, buf[:8]); err == io.EOF {
+		return v, nil
+	} else if err != nil {
+		return nil, err
+	}
+	v.logNumber = decodeInt(buf[:8])
+
+	return v, nil
+}
+
+// readLegacyVersion converts the meta of the older version into the version.
+func readLegacyVersion(dbDir string, data []byte) (*version, error) {
+	num, max := decodeIntPair(data)
+
+	v := &version{nextFileNumber: max + 1}
+	for id := max - num + 1; id <= max; id++ {
+		m, err := readTableMeta(dbDir, id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sstable %d: %w", id, err)
+		}
+		v.levels[0] = append(v.levels[0], m)
+	}
+
+	return v, nil
+}
+
+// readTableMeta reads the description of the specific SSTable from its files.
+func readTableMeta(dbDir string, id int) (*tableMeta, error) {
+	// the table is not cached, the tree is not opened yet.
+	c, err := newSsTableCursor(newTableCache(dbDir, 0), id, maxSequence)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+
+	m := &tableMeta{id: id, size: c.table.dataSize}
+	if err := c.first(); err != nil || !c.valid() {
+		return m, err
+	}
+	m.smallest = c.key()
+
+	if err := c.last(); err != nil {
+		return nil, err
+	}
+	m.largest = c.key()
+
+	return m, nil
+}
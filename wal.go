@@ -1,68 +1,479 @@
 package lsmtree
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
+	"sort"
+	"strconv"
+	"strings"
 )
 
 // @Author KHighness
-// @Update 2023-10-01
+// @Update 2026-10-16
 
-// closeWAL closes the current file and open the new file in the truncate mode.
-func clearWAL(dbDir string, wal *os.File) (*os.File, error) {
-	walPath := path.Join(dbDir, walFileName)
-	if err := wal.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close the WAL file %s: %w", walPath, err)
+const (
+	// legacyWalFileName is the name of the single WAL file written by the older
+	// version, legacyImmutableWalFileName is the name of its WAL file for the
+	// immutable MemTable. They are replayed and flushed by Open, then removed.
+	legacyWalFileName          = "wal.db"
+	legacyImmutableWalFileName = "wal-immutable.db"
+)
+
+// walSegmentPath returns the path of the WAL segment with the given number.
+func walSegmentPath(dbDir string, number int) string {
+	return path.Join(dbDir, strconv.Itoa(number)+"-"+walFileName)
+}
+
+// listWALSegments returns the numbers of all WAL segments in the directory
+// from the oldest to the newest.
+func listWALSegments(dbDir string) ([]int, error) {
+	infos, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", dbDir, err)
 	}
 
-	wal, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	numbers := make([]int, 0)
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, "-"+walFileName) {
+			continue
+		}
+		number, err := strconv.Atoi(strings.TrimSuffix(name, "-"+walFileName))
+		if err != nil {
+			continue
+		}
+		numbers = append(numbers, number)
+	}
+
+	sort.Ints(numbers)
+	return numbers, nil
+}
+
+// createWALSegment creates the new empty WAL segment with the given number.
+func createWALSegment(dbDir string, number int) (*os.File, error) {
+	segmentPath := walSegmentPath(dbDir, number)
+	wal, err := os.OpenFile(segmentPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open the file %s: %w", walPath, err)
+		return nil, fmt.Errorf("failed to open the file %s: %w", segmentPath, err)
+	}
+
+	// the segment must not be lost after the writes to it are synced.
+	if err := syncDir(dbDir); err != nil {
+		_ = wal.Close()
+		return nil, err
 	}
 
 	return wal, nil
 }
 
-// appendToWAL appends entry to the WAL file.
-func appendToWAL(wal *os.File, key []byte, value []byte) error {
-	if _, err := wal.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("failed to seek to the end: %w", err)
+// rotateWAL closes the current WAL segment and creates the new one with the
+// given number. The caller must sync the current segment if needed.
+func rotateWAL(dbDir string, wal *os.File, number int) (*os.File, error) {
+	if err := wal.Close(); err != nil {
+		return nil, fmt.Errorf("failed to close the WAL file %s: %w", wal.Name(), err)
 	}
 
-	if _, err := encode(key, value, wal); err != nil {
-		return fmt.Errorf("failed to encode and write to the file: %w", err)
+	return createWALSegment(dbDir, number)
+}
+
+// removeObsoleteWALSegments removes the WAL segments older than logNumber, whose
+// changes are durable in SSTables. If archiveDir is not empty, the segments
+// are moved into it instead.
+func removeObsoleteWALSegments(dbDir, archiveDir string, logNumber int) error {
+	numbers, err := listWALSegments(dbDir)
+	if err != nil {
+		return err
 	}
 
-	if err := wal.Sync(); err != nil {
-		return fmt.Errorf("failed to sync the file: %w", err)
+	for _, number := range numbers {
+		if number >= logNumber {
+			break
+		}
+
+		segmentPath := walSegmentPath(dbDir, number)
+		if archiveDir != "" {
+			archivePath := walSegmentPath(archiveDir, number)
+			if err := os.Rename(segmentPath, archivePath); err != nil {
+				return fmt.Errorf("failed to archive the file %s: %w", segmentPath, err)
+			}
+			continue
+		}
+		if err := os.Remove(segmentPath); err != nil {
+			return fmt.Errorf("failed to remove the file %s: %w", segmentPath, err)
+		}
 	}
 
 	return nil
 }
 
-// loadMemTable loads MemTable from the WAL file.
-func loadMemTable(wal *os.File) (*memTable, error) {
-	if _, err := wal.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to the start: %w", err)
+// legacyWALPaths returns the paths of the existing WAL files written by the
+// older version. The immutable WAL file is older, so it goes first.
+func legacyWALPaths(dbDir string) ([]string, error) {
+	paths := make([]string, 0, 2)
+	for _, name := range []string{legacyImmutableWalFileName, legacyWalFileName} {
+		legacyPath := path.Join(dbDir, name)
+		if _, err := os.Stat(legacyPath); err == nil {
+			paths = append(paths, legacyPath)
+		} else if !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to stat file %s: %w", legacyPath, err)
+		}
+	}
+
+	return paths, nil
+}
+
+// isLegacyWAL returns true if the path is the legacy WAL file.
+func isLegacyWAL(walPath string) bool {
+	name := path.Base(walPath)
+	return name == legacyWalFileName || name == legacyImmutableWalFileName
+}
+
+// removeLegacyWAL removes the legacy WAL files once their changes are durable in SSTables.
+func removeLegacyWAL(paths []string) error {
+	for _, legacyPath := range paths {
+		if err := os.Remove(legacyPath); err != nil {
+			return fmt.Errorf("failed to remove the file %s: %w", legacyPath, err)
+		}
+	}
+
+	return nil
+}
+
+// recoverWAL replays the WAL segments starting from logNumber and then the
+// legacy WAL files into the new MemTable, lastSeq is the greatest sequence number
+// before them. The legacy files are replayed under their names and are not
+// changed, the caller removes them once the MemTable is flushed. The newest
+// segment is kept open to append the next records, the new segment with the
+// given number is created if there are no segments. Returns the MemTable,
+// the open segment and its number.
+func recoverWAL(dbDir string, logNumber, number int, lastSeq uint64, legacyPaths []string, mode WALRecoveryMode, stats *RecoveryStats) (*memTable, *os.File, int, error) {
+	numbers, err := listWALSegments(dbDir)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+
+	live := make([]int, 0, len(numbers))
+	for _, n := range numbers {
+		if n >= logNumber {
+			live = append(live, n)
+		}
 	}
 
 	mt := newMemTable()
-	for {
-		key, value, err := decode(wal)
+	mt.lastSeq = lastSeq
+	var wal *os.File
+	for i, n := range live {
+		segmentPath := walSegmentPath(dbDir, n)
+		segment, err := os.OpenFile(segmentPath, os.O_RDWR, 0600)
 		if err != nil {
-			if err == io.EOF {
-				return mt, nil
-			} else {
-				return nil, fmt.Errorf("failed to read: %w", err)
+			return nil, nil, 0, fmt.Errorf("failed to open file %s: %w", segmentPath, err)
+		}
+
+		last := i == len(live)-1 && len(legacyPaths) == 0
+		if err := replayWAL(segment, mt, mode, stats, last, true, false); err != nil {
+			_ = segment.Close()
+			return nil, nil, 0, fmt.Errorf("failed to replay %s: %w", segmentPath, err)
+		}
+
+		if i < len(live)-1 {
+			if err := segment.Close(); err != nil {
+				return nil, nil, 0, fmt.Errorf("failed to close file %s: %w", segmentPath, err)
 			}
+			continue
+		}
+		wal, number = segment, n
+	}
+
+	if err := replayWALFiles(legacyPaths, mt, mode, stats); err != nil {
+		if wal != nil {
+			_ = wal.Close()
+		}
+		return nil, nil, 0, err
+	}
+
+	if wal == nil {
+		if wal, err = createWALSegment(dbDir, number); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+
+	return mt, wal, number, nil
+}
+
+// readWAL reads the WAL segments starting from logNumber and the legacy WAL
+// files into the new MemTable without changing them, lastSeq is the greatest
+// sequence number before them. The legacy files are newer than the segments,
+// see recoverWAL.
+func readWAL(dbDir string, logNumber int, lastSeq uint64, mode WALRecoveryMode, stats *RecoveryStats) (*memTable, error) {
+	numbers, err := listWALSegments(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := make([]string, 0, len(numbers)+2)
+	for _, n := range numbers {
+		if n >= logNumber {
+			paths = append(paths, walSegmentPath(dbDir, n))
+		}
+	}
+	legacyPaths, err := legacyWALPaths(dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	mt := newMemTable()
+	mt.lastSeq = lastSeq
+	if err := replayWALFiles(append(paths, legacyPaths...), mt, mode, stats); err != nil {
+		return nil, err
+	}
+
+	return mt, nil
+}
+
+// replayWALFiles replays the WAL files into the MemTable without changing them.
+// The last file may have the torn tail, see replayWAL.
+func replayWALFiles(paths []string, mt *memTable, mode WALRecoveryMode, stats *RecoveryStats) error {
+	for i, walPath := range paths {
+		wal, err := os.OpenFile(walPath, os.O_RDONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open file %s: %w", walPath, err)
+		}
+
+		err = replayWAL(wal, mt, mode, stats, i == len(paths)-1, false, isLegacyWAL(walPath))
+		_ = wal.Close()
+		if err != nil {
+			return fmt.Errorf("failed to replay %s: %w", walPath, err)
 		}
+	}
+
+	return nil
+}
+
+const (
+	// walChecksumFlag is set in the length of the record which has checksums.
+	// The records written by the older version have no checksums.
+	walChecksumFlag = 1 << 62
+	// walHeaderSize is the size of the record header with checksums.
+	walHeaderSize = 8 + 2*checksumSize
+)
+
+// appendToWAL appends the batches to the WAL file, each batch as a single record,
+// and syncs the file if sync is true. Returns the number of bytes written.
+//
+//	Record format:
+//	[encode batch length in bytes | walChecksumFlag]
+//	[checksum of the length][checksum of the batch][encoded batch]
+//
+// The records are written by a single write, so every batch is either
+// fully present in the file or is the torn tail of the file.
+func appendToWAL(wal *os.File, sync bool, batches ...*WriteBatch) (int, error) {
+	if _, err := wal.Seek(0, io.SeekEnd); err != nil {
+		return 0, fmt.Errorf("failed to seek to the end: %w", err)
+	}
+
+	var records bytes.Buffer
+	for _, batch := range batches {
+		data, err := encodeBatch(batch)
+		if err != nil {
+			return 0, fmt.Errorf("failed to encode batch: %w", err)
+		}
+		encodeRecord(data, &records)
+	}
+
+	if _, err := wal.Write(records.Bytes()); err != nil {
+		return 0, fmt.Errorf("failed to write to the file: %w", err)
+	}
+
+	if sync {
+		if err := wal.Sync(); err != nil {
+			return 0, fmt.Errorf("failed to sync the file: %w", err)
+		}
+	}
+
+	return records.Len(), nil
+}
+
+// encodeRecord writes the data with the record header into the buffer.
+// The function must be compatible with decodeRecord.
+func encodeRecord(data []byte, buf *bytes.Buffer) {
+	encodedLen := encodeInt(len(data) | walChecksumFlag)
+	buf.Write(encodedLen)
+	buf.Write(encodeChecksum(encodedLen))
+	buf.Write(encodeChecksum(data))
+	buf.Write(data)
+}
+
+// errIncompleteRecord is returned by decodeRecord if the record is not fully written.
+var errIncompleteRecord = errors.New("incomplete record")
+
+// decodeRecord decodes the record at the beginning of data and returns its
+// data and size. Returns errIncompleteRecord if the record is not fully written
+// and CorruptionError without the path and offset if the record is corrupted.
+// The size of the corrupted record is 0 if its length is corrupted, so the
+// next record cannot be found.
+func decodeRecord(data []byte) ([]byte, int, error) {
+	if len(data) < 8 {
+		return nil, len(data), errIncompleteRecord
+	}
+
+	length := decodeInt(data[:8])
+	headerSize := 8
+	hasChecksum := length&walChecksumFlag != 0
+	if hasChecksum {
+		headerSize = walHeaderSize
+		if len(data) < headerSize {
+			return nil, len(data), errIncompleteRecord
+		}
+		// the length is verified before the data is read, so the corrupted
+		// length is not mistaken for the torn record.
+		if !verifyChecksum(data[8:12], data[:8]) {
+			return nil, 0, &CorruptionError{Reason: "record length checksum mismatch"}
+		}
+		length &^= walChecksumFlag
+	}
+
+	if length < 0 || length > len(data)-headerSize {
+		return nil, len(data), errIncompleteRecord
+	}
+
+	size := headerSize + length
+	recordData := data[headerSize:size]
+	if hasChecksum && !verifyChecksum(data[12:16], recordData) {
+		return nil, size, &CorruptionError{Reason: "record checksum mismatch"}
+	}
+
+	return recordData, size, nil
+}
+
+// decodeWALRecord decodes the WAL record at the beginning of data and returns
+// the batch and the size of the record. The record of the legacy WAL file may
+// be written by the first version, see decodeLegacyRecord. The errors are the
+// same as decodeRecord.
+func decodeWALRecord(data []byte, legacy bool) (*WriteBatch, int, error) {
+	batchData, size, err := decodeRecord(data)
+	if err != nil {
+		return nil, size, err
+	}
+
+	batch, err := decodeBatch(batchData)
+	if err != nil && legacy {
+		batch, err = decodeLegacyRecord(batchData)
+	}
+	if err != nil {
+		return nil, size, &CorruptionError{Reason: fmt.Sprintf("failed to decode batch: %s", err)}
+	}
+	return batch, size, nil
+}
+
+// decodeLegacyRecord decodes the record written by the first version, which
+// holds the single put or delete encoded by encode without the batch framing.
+// The length of the encoded entry is the header of the record, so data is
+// the key length followed by the key and the value. The empty value is the
+// deletion.
+func decodeLegacyRecord(data []byte) (*WriteBatch, error) {
+	if len(data) < 8 {
+		return nil, fmt.Errorf("the record is corrupted, failed to read key length")
+	}
+
+	keyLen := decodeInt(data[:8])
+	if keyLen < 0 || keyLen > len(data)-8 {
+		return nil, fmt.Errorf("the record is corrupted, bad key length %d", keyLen)
+	}
+	key, value := data[8:8+keyLen], data[8+keyLen:]
+
+	b := NewWriteBatch()
+	if len(value) == 0 {
+		b.entries = append(b.entries, entry{key: key})
+	} else {
+		b.entries = append(b.entries, entry{key: key, value: value})
+	}
+	return b, nil
+}
+
+// replayWAL applies the records of the WAL file to the MemTable. The torn or
+// corrupted records are handled according to the recovery mode, the dropped
+// tail is truncated if truncate is true and ignored otherwise. The outcome is
+// added to the stats.
+//
+// Only the last WAL file may have the torn tail, the older files were complete
+// before the next one was created, so their torn tail is the corruption. The
+// records written by the first version are accepted only if legacy is true.
+func replayWAL(wal *os.File, mt *memTable, mode WALRecoveryMode, stats *RecoveryStats, last, truncate, legacy bool) error {
+	if _, err := wal.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek to the start: %w", err)
+	}
+
+	data, err := ioutil.ReadAll(wal)
+	if err != nil {
+		return fmt.Errorf("failed to read: %w", err)
+	}
+
+	offset := 0
+	for offset < len(data) {
+		batch, size, err := decodeWALRecord(data[offset:], legacy)
+		if err == nil {
+			// the batch written by the older version has no sequence number.
+			if batch.seq == 0 {
+				batch.seq = mt.lastSeq + 1
+				stats.unsequenced++
+			}
+			mt.apply(batch)
+			stats.Records++
+			offset += size
+			continue
+		}
+
+		var corruption *CorruptionError
+		if err == errIncompleteRecord {
+			corruption = &CorruptionError{Reason: err.Error()}
+		} else if !errors.As(err, &corruption) {
+			return err
+		}
+		corruption.Path, corruption.Offset = wal.Name(), int64(offset)
+
+		if mode == AbsoluteConsistency {
+			return corruption
+		}
+		stats.Corruptions = append(stats.Corruptions, corruption)
+
+		// the tail of the file is the torn record or the corrupted record followed
+		// by zeros, which may be left by the crash during the write. It is always
+		// dropped, the corrupted record in the middle is skipped if possible.
+		tail := err == errIncompleteRecord || (size > 0 && isZero(data[offset+size:]))
+		if !tail && mode == SkipAnyCorruptedRecords && size > 0 {
+			stats.SkippedRecords++
+			offset += size
+			continue
+		} else if (!tail || !last) && mode == TolerateCorruptedTailRecords {
+			return corruption
+		}
+
+		if truncate {
+			if err := wal.Truncate(int64(offset)); err != nil {
+				return fmt.Errorf("failed to truncate the tail: %w", err)
+			}
+		}
+		stats.TruncatedBytes += int64(len(data) - offset)
+		break
+	}
+
+	if _, err := wal.Seek(0, io.SeekEnd); err != nil {
+		return fmt.Errorf("failed to seek to the end: %w", err)
+	}
+	return nil
+}
 
-		if value != nil {
-			mt.put(key, value)
-		} else {
-			mt.delete(key)
+// isZero returns true if all bytes of data are zero.
+func isZero(data []byte) bool {
+	for _, b := range data {
+		if b != 0 {
+			return false
 		}
 	}
+	return true
 }
@@ -0,0 +1,274 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"testing"
+)
+
+// @Author KHighness
+// @Update 2026-10-16
+
+func TestLSMTree_WALSegments(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	archiveDir, err := ioutil.TempDir(os.TempDir(), "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(archiveDir)
+
+	options := []func(*LSMTree){WALSegmentSize(100), WALArchiveDir(archiveDir)}
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	putRange(t, tree, 0, 50)
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	segments, err := listWALSegments(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) < 10 {
+		t.Fatalf("the WAL must be rotated at the size limit, segments=%v", segments)
+	}
+
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkRange(t, tree, 0, 50)
+	if stats := tree.RecoveryStats(); stats.Records != 50 {
+		t.Fatalf("all records must be recovered from the segments, stats=%+v", stats)
+	}
+
+	if err := tree.flush(); err != nil {
+		t.Fatal(err)
+	}
+	if live, err := listWALSegments(dbDir); err != nil || len(live) != 1 {
+		t.Fatalf("the flushed WAL segments must be obsolete, segments=%v err=%v", live, err)
+	}
+	if archived, err := listWALSegments(archiveDir); err != nil || len(archived) != len(segments) {
+		t.Fatalf("the obsolete WAL segments must be archived, archived=%v err=%v", archived, err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	checkRange(t, tree, 0, 50)
+	if stats := tree.RecoveryStats(); stats.Records != 0 {
+		t.Fatalf("the obsolete WAL segments must not be replayed, stats=%+v", stats)
+	}
+}
+
+func TestLSMTree_LegacyWAL(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// the older version kept the immutable MemTable and the current one
+	// in the two WAL files.
+	for _, name := range []string{legacyImmutableWalFileName, legacyWalFileName} {
+		wal, err := os.Create(path.Join(dbDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		batch := NewWriteBatch()
+		batch.Put([]byte("key"), []byte(name))
+		batch.Put([]byte(name), []byte(name))
+		if _, err := appendToWAL(wal, true, batch); err != nil {
+			t.Fatal(err)
+		}
+		if err := wal.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for key, expected := range map[string]string{
+		"key":                      legacyWalFileName,
+		legacyWalFileName:          legacyWalFileName,
+		legacyImmutableWalFileName: legacyImmutableWalFileName,
+	} {
+		if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != expected {
+			t.Fatalf("Get key=%s expected value=%s, actual value=%s ok=%v err=%v", key, expected, value, ok, err)
+		}
+	}
+
+	for _, name := range []string{legacyImmutableWalFileName, legacyWalFileName} {
+		if _, err := os.Stat(path.Join(dbDir, name)); !os.IsNotExist(err) {
+			t.Fatalf("the legacy WAL file %s must be removed after the flush, stat err=%v", name, err)
+		}
+	}
+	if tables := tableNum(tree); tables != 1 {
+		t.Fatalf("the legacy WAL files expected to be flushed into 1 table, actual %d", tables)
+	}
+}
+
+func TestLSMTree_BaselineWAL(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// the first version wrote every put and delete by encode without the
+	// batch framing, the deletion has the empty value.
+	wal, err := os.Create(path.Join(dbDir, legacyWalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		key := []byte(fmt.Sprintf("key-%04d", i))
+		if _, err := encode(key, key, wal); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := encode([]byte("key-0005"), nil, wal); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(tree *LSMTree) {
+		t.Helper()
+		checkRange(t, tree, 0, 5)
+		checkRange(t, tree, 6, 30)
+		if _, ok, err := tree.Get([]byte("key-0005")); err != nil || ok {
+			t.Fatalf("Get expected the deleted key, actual ok=%v err=%v", ok, err)
+		}
+	}
+
+	readOnly, err := OpenReadOnly(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check(readOnly)
+	if err := readOnly.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		tree, err := Open(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		check(tree)
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := os.Stat(path.Join(dbDir, legacyWalFileName)); !os.IsNotExist(err) {
+		t.Fatalf("the legacy WAL file must be removed after the flush, stat err=%v", err)
+	}
+}
+
+func TestLSMTree_LegacyWALFailedOpen(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// the corrupted record is followed by the valid one, so Open fails.
+	wal, err := os.Create(path.Join(dbDir, legacyWalFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		batch := NewWriteBatch()
+		batch.Put([]byte("key"), []byte("value"))
+		if _, err := appendToWAL(wal, true, batch); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := wal.WriteAt([]byte{0xff}, walHeaderSize+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := wal.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dbDir); err == nil {
+		t.Fatalf("Open expected to fail on the corrupted legacy WAL file")
+	}
+	infos, err := ioutil.ReadDir(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(infos) != 1 || infos[0].Name() != legacyWalFileName {
+		names := make([]string, 0, len(infos))
+		for _, info := range infos {
+			names = append(names, info.Name())
+		}
+		t.Fatalf("the failed Open must not change the directory, actual files %v", names)
+	}
+}
+
+func TestLSMTree_UnsequencedWAL(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dbDir)
+
+	// the older version wrote the batches without sequence numbers.
+	var data, records bytes.Buffer
+	data.Write(encodeInt(1))
+	if _, err := encode([]byte("key"), []byte("value"), &data); err != nil {
+		t.Fatal(err)
+	}
+	encodeRecord(data.Bytes(), &records)
+	if err := ioutil.WriteFile(walSegmentPath(dbDir, 1), records.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.DeleteRange([]byte("a"), []byte("z")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the record numbered by the recovery must stay older than the deletion
+	// after the reopens.
+	for i := 0; i < 2; i++ {
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if tree, err = Open(dbDir); err != nil {
+			t.Fatal(err)
+		}
+		if _, ok, err := tree.Get([]byte("key")); err != nil || ok {
+			_ = tree.Close()
+			t.Fatalf("Get expected the deleted key, actual ok=%v err=%v", ok, err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+}